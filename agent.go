@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ENV_SSH_AUTH_SOCK = "SSH_AUTH_SOCK"
+
+	AGENT_TIMEOUT = 5 * time.Second
+
+	// ssh agent protocol (draft-miller-ssh-agent)
+	SSH_AGENTC_REQUEST_IDENTITIES = 11
+	SSH_AGENT_IDENTITIES_ANSWER   = 12
+
+	OPENSSH_PRIVATE_KEY_MAGIC = "openssh-key-v1\x00"
+)
+
+func SshAgentLifetimeCheck(lifetime string) error {
+	if lifetime == "" {
+		return nil
+	}
+
+	if d, err := time.ParseDuration(lifetime); err != nil || d < time.Second {
+		return fmt.Errorf("invalid SshAgentLifetime value: %s. allow duration(ex: 30m, 8h) or \"\"(default, no lifetime)", lifetime)
+	}
+
+	return nil
+}
+
+// ListAgentKeys returns public key blobs in ssh agent.
+func ListAgentKeys(socket string) ([][]byte, error) {
+	conn, err := net.DialTimeout("unix", socket, AGENT_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(AGENT_TIMEOUT))
+
+	if _, err := conn.Write([]byte{0, 0, 0, 1, SSH_AGENTC_REQUEST_IDENTITIES}); err != nil {
+		return nil, err
+	}
+
+	var l uint32
+	if err := binary.Read(conn, binary.BigEndian, &l); err != nil {
+		return nil, err
+	}
+
+	// agent message is small. (256KB is enough for many keys)
+	if l == 0 || l > 256*1024 {
+		return nil, fmt.Errorf("invalid agent response length: %d", l)
+	}
+
+	msg := make([]byte, l)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+
+	if msg[0] != SSH_AGENT_IDENTITIES_ANSWER {
+		return nil, fmt.Errorf("unexpected agent response: %d", msg[0])
+	}
+
+	r := &certReader{b: msg[1:]}
+	n := r.uint32()
+	keys := make([][]byte, 0, n)
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		blob := r.bytes()
+		r.bytes() // comment
+		keys = append(keys, blob)
+	}
+
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid agent response: %s", r.err.Error())
+	}
+
+	return keys, nil
+}
+
+// identityPublicKey returns public key blob of the identity file.
+// it reads <identity file>.pub, or public key in OpenSSH format private key (not encrypted part).
+func identityPublicKey(identityFile string) ([]byte, error) {
+	if content, err := os.ReadFile(identityFile + ".pub"); err == nil {
+		fields := strings.Fields(string(content))
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid public key: %s.pub", identityFile)
+		}
+
+		return base64.StdEncoding.DecodeString(fields[1])
+	}
+
+	content, err := os.ReadFile(identityFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" || !bytes.HasPrefix(block.Bytes, []byte(OPENSSH_PRIVATE_KEY_MAGIC)) {
+		return nil, fmt.Errorf("public key of %s is unknown. please put %s.pub", identityFile, identityFile)
+	}
+
+	// ciphername, kdfname, kdfoptions, number of keys, first public key
+	r := &certReader{b: block.Bytes[len(OPENSSH_PRIVATE_KEY_MAGIC):]}
+	r.bytes()
+	r.bytes()
+	r.bytes()
+	r.uint32()
+	pub := r.bytes()
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid private key %s: %s", identityFile, r.err.Error())
+	}
+
+	return pub, nil
+}
+
+// agentSocket returns ssh_agent_socket config or SSH_AUTH_SOCK.
+func agentSocket(rOpt *RnsshOption) (string, error) {
+	if rOpt.AgentSocket != "" {
+		return expandHomeDir(rOpt.AgentSocket)
+	}
+
+	return os.Getenv(ENV_SSH_AUTH_SOCK), nil
+}
+
+// prepareAgent adds the identity file to ssh agent if it is not loaded.
+// the identity file in agent is not passed with -i, so passphrase is not asked every time.
+func (a *App) prepareAgent(rOpt *RnsshOption, identityFile string, showCommand bool) error {
+	if !rOpt.Agent || identityFile == "" {
+		return nil
+	}
+
+	socket, err := agentSocket(rOpt)
+	if err != nil {
+		return err
+	}
+
+	if socket == "" {
+		fmt.Fprintf(a.Stderr, "warn: ssh agent is not found (%s is empty). use identity file.\n", ENV_SSH_AUTH_SOCK)
+		return nil
+	}
+
+	path, err := expandHomeDir(identityFile)
+	if err != nil {
+		return err
+	}
+
+	pub, err := identityPublicKey(path)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "warn: can not check ssh agent: %s\n", err.Error())
+		return nil
+	}
+
+	keys, err := ListAgentKeys(socket)
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "warn: can not connect to ssh agent %s: %s. use identity file.\n", socket, err.Error())
+		return nil
+	}
+
+	for _, k := range keys {
+		if bytes.Equal(k, pub) {
+			rOpt.IdentityFileInAgent = identityFile
+			return nil
+		}
+	}
+
+	name, args := "ssh-add", make([]string, 0, 5)
+	// ssh-add uses the socket of the profile. (env of rnssh is not changed)
+	if rOpt.AgentSocket != "" {
+		name, args = "env", append(args, ENV_SSH_AUTH_SOCK+"="+socket, "ssh-add")
+	}
+
+	if rOpt.AgentLifetime > 0 {
+		args = append(args, "-t", fmt.Sprint(int(rOpt.AgentLifetime.Seconds())))
+	}
+	args = append(args, path)
+
+	if err := a.runOrShow(showCommand, name, args); err != nil {
+		return fmt.Errorf("can not add %s to ssh agent: %s", identityFile, err.Error())
+	}
+
+	// the key is not added yet, so shown ssh command keeps -i.
+	if !showCommand {
+		rOpt.IdentityFileInAgent = identityFile
+	}
+
+	return nil
+}
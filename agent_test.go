@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// startFakeAgent serves identities request with keys.
+func startFakeAgent(t *testing.T, keys ...[]byte) string {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			req := make([]byte, 5)
+			if _, err := io.ReadFull(conn, req); err == nil && req[4] == SSH_AGENTC_REQUEST_IDENTITIES {
+				msg := []byte{SSH_AGENT_IDENTITIES_ANSWER}
+				msg = binary.BigEndian.AppendUint32(msg, uint32(len(keys)))
+				for _, k := range keys {
+					msg = binary.BigEndian.AppendUint32(msg, uint32(len(k)))
+					msg = append(msg, k...)
+					msg = binary.BigEndian.AppendUint32(msg, 4)
+					msg = append(msg, "test"...)
+				}
+				conn.Write(append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...))
+			}
+			conn.Close()
+		}
+	}()
+
+	return socket
+}
+
+func TestIdentityPublicKey(t *testing.T) {
+	dir := t.TempDir()
+	k := testHostKey("ssh-ed25519", "ed25519-body")
+	blob, _ := k.blob()
+
+	withPub := filepath.Join(dir, "with_pub")
+	os.WriteFile(withPub, []byte("encrypted"), 0600)
+	os.WriteFile(withPub+".pub", []byte(k.String()+" alice\n"), 0600)
+
+	// OpenSSH private key has public key before encrypted part.
+	var b []byte
+	for _, s := range [][]byte{[]byte("aes256-ctr"), []byte("bcrypt"), []byte("salt")} {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+		b = append(b, s...)
+	}
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(blob)))
+	b = append(b, blob...)
+	openssh := filepath.Join(dir, "openssh")
+	os.WriteFile(openssh, pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte(OPENSSH_PRIVATE_KEY_MAGIC), b...)}), 0600)
+
+	for _, path := range []string{withPub, openssh} {
+		pub, err := identityPublicKey(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(pub, blob) {
+			t.Errorf("%s: unexpected public key: %v", path, pub)
+		}
+	}
+
+	pemKey := filepath.Join(dir, "rsa.pem")
+	os.WriteFile(pemKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("x")}), 0600)
+	if _, err := identityPublicKey(pemKey); err == nil || !strings.Contains(err.Error(), "please put") {
+		t.Errorf("expected error of unknown public key but %v", err)
+	}
+}
+
+func TestAppRunSshAgent(t *testing.T) {
+	t.Setenv(ENV_SSH_AUTH_SOCK, "")
+
+	k := testHostKey("ssh-ed25519", "ed25519-body")
+	blob, _ := k.blob()
+	other := testHostKey("ssh-ed25519", "other-body")
+	otherBlob, _ := other.blob()
+
+	prodSocket := startFakeAgent(t, blob)
+	stgSocket := startFakeAgent(t, otherBlob)
+	a, key := newCertTestApp(t, "  ssh_agent = true\n  ssh_agent_lifetime = \"8h\"\n\n[[profiles]]\n  profile_name = \"prod\"\n  ssh_agent_socket = \""+prodSocket+"\"\n"+
+		"\n[[profiles]]\n  profile_name = \"stg\"\n  ssh_agent_socket = \""+stgSocket+"\"\n")
+	if err := os.WriteFile(key+".pub", []byte(k.String()+" alice\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// not loaded key is added with lifetime, and ssh does not use -i.
+	added := make([][]string, 0)
+	a.RunCommand = func(name string, args ...string) error {
+		if name == "ssh-add" {
+			added = append(added, args)
+			return nil
+		}
+		return a.Recorder.Run(name, args...)
+	}
+
+	t.Setenv(ENV_SSH_AUTH_SOCK, startFakeAgent(t, otherBlob))
+
+	// show command does not add the key, so ssh uses -i.
+	if code := a.Run([]string{"-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "ssh-add -t 28800 "+key+"\nssh -i"+key+" 203.0.113.1\n") || len(added) != 0 {
+		t.Errorf("expected ssh-add and ssh with -i are shown but %q %v", a.Out.String(), added)
+	}
+
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !reflect.DeepEqual(added, [][]string{{"-t", "28800", key}}) {
+		t.Errorf("unexpected ssh-add: %v", added)
+	}
+
+	if !reflect.DeepEqual(a.Recorder.Args, []string{"203.0.113.1"}) {
+		t.Errorf("expected ssh without -i but %v", a.Recorder.Args)
+	}
+
+	// loaded key in profile agent.
+	added = added[:0]
+	if code := a.Run([]string{"-profile", "prod"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if len(added) != 0 {
+		t.Errorf("expected loaded key is not added but %v", added)
+	}
+
+	if !reflect.DeepEqual(a.Recorder.Args, []string{"-oIdentityAgent=" + prodSocket, "203.0.113.1"}) {
+		t.Errorf("expected IdentityAgent without -i but %v", a.Recorder.Args)
+	}
+
+	// not loaded key is added to the profile agent without changing SSH_AUTH_SOCK of rnssh.
+	added = added[:0]
+	sock := os.Getenv(ENV_SSH_AUTH_SOCK)
+	a.RunCommand = func(name string, args ...string) error {
+		if name == "env" {
+			added = append(added, args)
+			return nil
+		}
+		return a.Recorder.Run(name, args...)
+	}
+
+	if code := a.Run([]string{"-profile", "stg"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !reflect.DeepEqual(added, [][]string{{ENV_SSH_AUTH_SOCK + "=" + stgSocket, "ssh-add", "-t", "28800", key}}) {
+		t.Errorf("unexpected ssh-add: %v", added)
+	}
+
+	if os.Getenv(ENV_SSH_AUTH_SOCK) != sock {
+		t.Errorf("expected %s is not changed but %s", ENV_SSH_AUTH_SOCK, os.Getenv(ENV_SSH_AUTH_SOCK))
+	}
+
+	if !reflect.DeepEqual(a.Recorder.Args, []string{"-oIdentityAgent=" + stgSocket, "203.0.113.1"}) {
+		t.Errorf("expected IdentityAgent without -i but %v", a.Recorder.Args)
+	}
+
+	// no agent uses identity file.
+	t.Setenv(ENV_SSH_AUTH_SOCK, "")
+	a.Out.Reset()
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !reflect.DeepEqual(a.Recorder.Args, []string{"-i" + key, "203.0.113.1"}) || !strings.Contains(a.Out.String(), "warn: ssh agent is not found") {
+		t.Errorf("expected identity file with warning but %v %q", a.Recorder.Args, a.Out.String())
+	}
+}
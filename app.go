@@ -0,0 +1,699 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/reiki4040/peco"
+)
+
+// CommandRunner runs external command (ssh, RDP client etc...)
+type CommandRunner func(name string, args ...string) error
+
+// App is rnssh command. main is thin shell of it, tests replace Selector, EC2 client and runner.
+type App struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// rnssh config and cache dir. default ~/.rnssh
+	RnsshDir string
+
+	// project config (.rnssh.toml) is searched from here to upwards. empty is not searched.
+	WorkDir string
+
+	// nil is the selector by -selector option or config.
+	Selector     Selector
+	NewEC2Client func(region, endpoint string) (EC2API, error)
+	RunCommand   CommandRunner
+
+	// finds command (tmux, screen) in PATH.
+	LookPath func(file string) (string, error)
+}
+
+func NewApp() *App {
+	a := &App{
+		Stdin:        os.Stdin,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		RnsshDir:     getRnsshDir(),
+		NewEC2Client: NewEC2Client,
+		LookPath:     exec.LookPath,
+	}
+	a.WorkDir, _ = os.Getwd()
+	a.RunCommand = a.runCommand
+
+	return a
+}
+
+func (a *App) runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = a.Stdin
+	cmd.Stdout = a.Stdout
+	cmd.Stderr = a.Stderr
+	return cmd.Run()
+}
+
+func (a *App) selector(name string, options []string, preview *Previewer) Selector {
+	if a.Selector != nil {
+		return a.Selector
+	}
+
+	return NewSelector(name, options, preview, a.Stdin, a.Stderr)
+}
+
+func (a *App) NewEC2Handler(endpoint string, cacheTTL time.Duration) *EC2Handler {
+	return &EC2Handler{
+		CacheDir: a.RnsshDir,
+		CacheTTL: cacheTTL,
+		// stale host keys are removed even if instance_known_hosts is off now.
+		KnownHostsFile: knownHostsPath(a.RnsshDir),
+		NewClient: func(region string) (EC2API, error) {
+			return a.NewEC2Client(region, endpoint)
+		},
+		Warn: a.Stderr,
+	}
+}
+
+// Run runs rnssh with command line args (without program name) and returns exit code.
+func (a *App) Run(args []string) int {
+	if err := a.run(args); err != nil {
+		// ssh (or RDP client) already showed the error.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+
+		fmt.Fprintf(a.Stdout, "%s\n", err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+func (a *App) run(args []string) error {
+	if len(args) > 0 {
+		if c := findCommand(args[0]); c != nil {
+			if isCommandArgs(c, args[1:]) {
+				return a.runSubcommand(c, args[1:])
+			}
+
+			// bare command name is query as before, but it may be mistake.
+			example := strings.Join(append([]string{"rnssh", c.Name, "--"}, args[1:]...), " ")
+			fmt.Fprintf(a.Stderr, "hint: %s is used as query. put option or -- after it to run the command. (ex: %s)\n", c.Name, example)
+		}
+	}
+
+	// legacy style. rnssh [options] [user@]query
+	opt, queries, err := ParseCommandOption(args, a.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if opt.ShowUsage {
+		return WriteUsage(a.Stdout)
+	}
+
+	if opt.ShowVersion {
+		fmt.Fprintf(a.Stdout, "%s (%s)", version, revision)
+		return nil
+	}
+
+	if opt.Completion != "" {
+		return WriteCompletionScript(a.Stdout, opt.Completion)
+	}
+
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+
+	if opt.InitWizard {
+		return a.configWizard(opt)
+	}
+
+	if opt.Complete != "" {
+		// without warnings. (shell shows stderr)
+		conf, _, err := a.readConfig()
+		if err != nil {
+			return err
+		}
+
+		return WriteCompletionCandidates(a.Stdout, opt.Complete, conf, a.RnsshDir)
+	}
+
+	files, err := a.loadConfigFiles(opt)
+	if err != nil {
+		return err
+	}
+
+	rOpt, err := a.rnsshOption(files, opt)
+	if err != nil {
+		return err
+	}
+
+	handler := a.NewEC2Handler(rOpt.EC2Endpoint, rOpt.CacheTTL)
+	if opt.Describe != "" {
+		e, err := handler.DescribeCachedInstance(rOpt.Region, rOpt.HostType, rOpt.NetworkInterface, opt.Describe)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(a.Stdout, e.Describe())
+		return nil
+	}
+
+	return a.ssh(rOpt, handler, opt, queries)
+}
+
+func (a *App) configPath() string {
+	return filepath.Join(a.RnsshDir, "config")
+}
+
+// readConfig reads rnssh config without validation. no config file is empty config.
+// it returns warnings about unknown keys and old keys.
+func (a *App) readConfig() (*Config, []string, error) {
+	conf, warnings, err := ReadConfigFile(a.configPath())
+	if os.IsNotExist(err) {
+		return &Config{}, nil, nil
+	}
+
+	return conf, warnings, err
+}
+
+// loadConfig loads and validates rnssh config. warnings are written to stderr.
+func (a *App) loadConfig() (*Config, error) {
+	conf, warnings, err := a.readConfig()
+	if err != nil {
+		return nil, err
+	}
+	a.warnConfig(a.configPath(), warnings)
+
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+
+	return conf, nil
+}
+
+func (a *App) warnConfig(path string, warnings []string) {
+	for _, w := range warnings {
+		fmt.Fprintf(a.Stderr, "warn: %s: %s\n", path, w)
+	}
+}
+
+// loadConfigFiles loads rnssh config and trusted project config. (.rnssh.toml)
+// untrusted (new or changed) project config is used after user confirms it.
+// with -no-trust-prompt (preview pane), untrusted project config is ignored without prompt.
+func (a *App) loadConfigFiles(opt *CommandOption) ([]*configFile, error) {
+	conf, err := a.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []*configFile{{Source: SOURCE_CONFIG, Path: a.configPath(), Config: conf}}
+
+	path := findProjectConfig(a.WorkDir)
+	if path == "" {
+		return files, nil
+	}
+
+	// check and decode the same content. (file may be changed after check)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read project config %s: %s", path, err.Error())
+	}
+
+	trusted, err := loadTrustedConfigs(a.RnsshDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := contentSha256(content); trusted[path] != sum {
+		if opt.NoTrustPrompt {
+			return files, nil
+		}
+
+		if !confirmTrust(a.Stdin, a.Stderr, path) {
+			fmt.Fprintf(a.Stderr, "warn: ignored project config %s. run `rnssh config trust` to use it.\n", path)
+			return files, nil
+		}
+
+		trusted[path] = sum
+		if err := saveTrustedConfigs(a.RnsshDir, trusted); err != nil {
+			return nil, err
+		}
+	}
+
+	pConf, warnings, err := decodeProjectConfig(path, content)
+	if err != nil {
+		return nil, err
+	}
+	a.warnConfig(path, warnings)
+
+	return append(files, &configFile{Source: SOURCE_PROJECT, Path: path, Config: pConf}), nil
+}
+
+func (a *App) configWizard(opt *CommandOption) error {
+	w := &ConfigWizard{
+		Selector: a.selector(opt.Selector, nil, nil),
+		In:       a.Stdin,
+		Out:      a.Stderr,
+		Regions:  a.regionChoices,
+		SshDir:   filepath.Join(filepath.Dir(a.RnsshDir), ".ssh"),
+	}
+
+	if err := DoConfigWizard(a.configPath(), w, NewWizardAnswers(opt)); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(a.Stdout, "saved rnssh config.")
+	return nil
+}
+
+// regionChoices returns enabled regions from AWS. if it can not (no credentials etc...), returns built-in list.
+func (a *App) regionChoices() []peco.Choosable {
+	region := os.Getenv(ENV_AWS_REGION)
+	if region == "" {
+		region = DEFAULT_API_REGION
+	}
+
+	client, err := a.NewEC2Client(region, "")
+	if err == nil {
+		var regions []string
+		regions, err = GetRegions(client)
+		if err == nil && len(regions) > 0 {
+			return RegionChoices(regions)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(a.Stderr, "warn: can not get regions from AWS, use built-in list: %s\n", err.Error())
+	}
+
+	return AWSRegionList
+}
+
+// rnsshOption merges the profile config of config files and options.
+func (a *App) rnsshOption(files []*configFile, opt *CommandOption) (*RnsshOption, error) {
+	profile := profileName(opt)
+	layers, err := configLayers(files, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	rOpt, err := mergeConfig(*opt, layers...)
+	if err != nil {
+		return nil, err
+	}
+	rOpt.Profile = profile
+
+	if !rOpt.UseSshConfig && rOpt.Region == "" {
+		return nil, fmt.Errorf("region is empty. please specify by region option (-r) or set default region with --init option")
+	}
+
+	if rOpt.VerifyHostKey && rOpt.StrictHostKeyCheckingNo == 1 {
+		return nil, fmt.Errorf("can not verify host key with strict host key checking no. please set -strict-host-key-checking-no 0")
+	}
+
+	if rOpt.CertSignCommand != "" && rOpt.CertSignerURL != "" {
+		return nil, fmt.Errorf("can not specify both ssh_cert_sign_command and ssh_cert_signer_url")
+	}
+
+	if rOpt.Merged.InstanceKnownHosts || rOpt.VerifyHostKey {
+		rOpt.KnownHostsFile = knownHostsPath(a.RnsshDir)
+	}
+
+	return rOpt, nil
+}
+
+// chooseHost loads hosts and returns chosen host and ssh user that specified by user@ format.
+func (a *App) chooseHost(rOpt *RnsshOption, handler *EC2Handler, queries []string) (peco.Choosable, string, error) {
+	choosableList, err := loadChoosableList(rOpt, handler)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var preview *Previewer
+	if !rOpt.UseSshConfig {
+		preview = &Previewer{Command: genPreviewCommand(rOpt), Describe: DescribeChoosable}
+	}
+
+	selector := a.selector(rOpt.Selector, rOpt.SelectorOptions, preview)
+	return chooseTargetHost(selector, choosableList, queries)
+}
+
+// chooseHosts is chooseHost for multiple hosts.
+func (a *App) chooseHosts(rOpt *RnsshOption, handler *EC2Handler, queries []string) ([]peco.Choosable, string, error) {
+	choosableList, err := loadChoosableList(rOpt, handler)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var preview *Previewer
+	if !rOpt.UseSshConfig {
+		preview = &Previewer{Command: genPreviewCommand(rOpt), Describe: DescribeChoosable}
+	}
+
+	selector := a.selector(rOpt.Selector, rOpt.SelectorOptions, preview)
+	return chooseTargetHosts(selector, choosableList, queries)
+}
+
+// ssh chooses host and ssh login. remoteCommand is passed to ssh after host.
+func (a *App) ssh(rOpt *RnsshOption, handler *EC2Handler, opt *CommandOption, queries []string, remoteCommand ...string) error {
+	if opt.Tmux {
+		return a.sshMultiplexer(rOpt, handler, opt, queries, remoteCommand...)
+	}
+
+	targetHost, sshUser, err := a.chooseHost(rOpt, handler, queries)
+	if err != nil {
+		return err
+	}
+
+	// windows instance can not ssh, so connect with RDP.
+	if e, ok := targetHost.(*ChoosableEC2); ok && e.IsWindows() {
+		if len(remoteCommand) > 0 {
+			return fmt.Errorf("can not run command on windows instance %s (%s)", e.InstanceId, e.Name)
+		}
+		return ConnectRdp(rOpt, e, sshUser, handler, a.RnsshDir, a.Stdout, a.RunCommand, opt.ShowCommand)
+	}
+
+	if err := a.beforeConnect(rOpt, handler, targetHost, opt.ShowCommand); err != nil {
+		return err
+	}
+
+	sshArgs, err := genSshArgsForHost(rOpt, targetHost, sshUser)
+	if err != nil {
+		return err
+	}
+	sshArgs = append(sshArgs, remoteCommand...)
+
+	if rOpt.SessionRecording && !opt.ShowCommand {
+		return a.recordSsh(rOpt, targetHost, sshUser, sshArgs)
+	}
+
+	return a.runOrShow(opt.ShowCommand, "ssh", sshArgs)
+}
+
+// recordSsh runs ssh with session recording.
+func (a *App) recordSsh(rOpt *RnsshOption, targetHost peco.Choosable, sshUser string, sshArgs []string) error {
+	sshUser, _, _, err := resolveSshSettings(rOpt, targetHost, sshUser)
+	if err != nil {
+		return err
+	}
+
+	if sshUser == "" {
+		sshUser = rOpt.SshUser
+	}
+
+	// ssh uses local user name.
+	if sshUser == "" {
+		if u, err := user.Current(); err == nil {
+			sshUser = u.Username
+		}
+	}
+
+	meta := &SessionMeta{Host: targetHost.Value(), User: sshUser, Profile: rOpt.Profile, StartTime: time.Now()}
+	if e, ok := targetHost.(*ChoosableEC2); ok {
+		meta.InstanceId = e.InstanceId
+		meta.Name = e.Name
+		meta.Region = rOpt.Region
+	}
+
+	path := sessionPath(a.RnsshDir, meta)
+	fmt.Fprintf(a.Stderr, "rnssh: recording session to %s\n", path)
+
+	return RecordSession(path, meta, a.Stdin, a.Stdout, "ssh", sshArgs...)
+}
+
+// beforeConnect verifies host key, renews ssh certificate and loads key to ssh agent if they are configured.
+func (a *App) beforeConnect(rOpt *RnsshOption, handler *EC2Handler, targetHost peco.Choosable, showCommand bool) error {
+	if err := a.verifyHostKey(rOpt, handler, targetHost, showCommand); err != nil {
+		return err
+	}
+
+	if err := a.prepareCertificate(rOpt, showCommand); err != nil {
+		return err
+	}
+
+	_, identityFile, _, err := resolveSshSettings(rOpt, targetHost, "")
+	if err != nil {
+		return err
+	}
+
+	return a.prepareAgent(rOpt, identityFile, showCommand)
+}
+
+func (a *App) runOrShow(showCommand bool, name string, args []string) error {
+	if showCommand {
+		fmt.Fprintf(a.Stdout, "%s %s\n", name, strings.Join(args, " "))
+		return nil
+	}
+
+	return a.RunCommand(name, args...)
+}
+
+// ParseCommandOption parses command line args and returns option and query args.
+func ParseCommandOption(args []string, output io.Writer) (*CommandOption, []string, error) {
+	opt := &CommandOption{}
+	fs := newFlagSet(opt, output)
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	return opt, fs.Args(), nil
+}
+
+// hidden flags are not shown in completion.
+var hiddenFlags = map[string]bool{
+	"describe":        true,
+	"no-trust-prompt": true,
+	"ec2-endpoint":    true,
+	"complete":        true,
+}
+
+func newFlagSet(opt *CommandOption, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("rnssh", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() { WriteUsage(output) }
+
+	addHelpFlags(fs, opt)
+	fs.BoolVar(&opt.ShowVersion, "version", false, "show version.")
+	fs.BoolVar(&opt.ShowVersion, "v", false, "show version.")
+	fs.BoolVar(&opt.InitWizard, "init", false, "run initial configuration wizard. answers can be specified by options. (ex: -r, -host-type, -l, -i, -port)")
+	addInitFlags(fs, opt)
+
+	addTargetFlags(fs, opt)
+	addSelectorFlags(fs, opt)
+	addSshFlags(fs, opt)
+	addWindowsFlags(fs, opt)
+	addMultiplexerFlags(fs, opt)
+
+	fs.StringVar(&opt.Describe, "describe", "", "(internal) show instance details from cache for preview pane")
+	fs.BoolVar(&opt.NoTrustPrompt, "no-trust-prompt", false, "(internal) ignore untrusted project config without prompt")
+	fs.StringVar(&opt.EC2Endpoint, "ec2-endpoint", "", "(internal) EC2 API endpoint")
+	fs.StringVar(&opt.Complete, "complete", "", "(internal) list completion candidates")
+	fs.StringVar(&opt.Completion, "completion", "", "show shell completion script. bash, zsh or fish")
+
+	return fs
+}
+
+func addInitFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.NoPrompt, "no-prompt", false, "with init, do not ask. use default for the settings that are not specified by options")
+}
+
+func addHelpFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.ShowUsage, "h", false, "show this usage.")
+	fs.BoolVar(&opt.ShowUsage, "help", false, "show this usage.")
+}
+
+// flags for loading target hosts.
+func addTargetFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.Reload, "f", false, "reload ec2 (force connect to AWS)")
+	fs.BoolVar(&opt.Reload, "force", false, "reload ec2 (force connect to AWS)")
+	fs.BoolVar(&opt.PublicIP, "P", false, "ssh with EC2 Public IP")
+	fs.BoolVar(&opt.PublicIP, "public-ip", false, "ssh with EC2 Public IP")
+	fs.BoolVar(&opt.PrivateIP, "p", false, "ssh with EC2 Private IP")
+	fs.BoolVar(&opt.PrivateIP, "private-ip", false, "ssh with EC2 Private IP")
+	fs.BoolVar(&opt.NameTag, "n", false, "ssh with EC2 Name tag")
+	fs.BoolVar(&opt.NameTag, "name-tag", false, "ssh with EC2 Name tag")
+	fs.StringVar(&opt.HostType, "host-type", "", "ssh host type. public, private, name, ssm or comma separated fallback list")
+
+	fs.StringVar(&opt.Region, "r", "", "specify region")
+	fs.StringVar(&opt.Region, "region", "", "specify region")
+	fs.StringVar(&opt.Profile, "profile", "", "rnssh config profile name")
+
+	fs.BoolVar(&opt.UseSshConfig, "use-ssh-config", false, "load from ssh config")
+	fs.BoolVar(&opt.UseEC2, "use-ec2", false, "load from ec2")
+}
+
+func addSelectorFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.StringVar(&opt.Selector, "selector", "", "fuzzy finder. peco(default), fzf, sk or builtin")
+}
+
+func addSshFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.ShowCommand, "s", false, "show ssh command that will do (debug)")
+	fs.BoolVar(&opt.ShowCommand, "show-command", false, "show ssh command that will do (debug)")
+
+	fs.StringVar(&opt.SshUser, "l", "", "specify ssh user")
+	fs.StringVar(&opt.SshUser, "user", "", "specify ssh user")
+	fs.StringVar(&opt.IdentityFile, "i", "", "specify ssh identity file")
+	fs.StringVar(&opt.IdentityFile, "identity-file", "", "specify ssh identity file")
+	fs.IntVar(&opt.Port, "port", 0, "specify ssh port")
+	fs.IntVar(&opt.StrictHostKeyCheckingNo, "strict-host-key-checking-no", -1, "suppress host key checking. 1: ON, 0: OFF, -1: default(OFF)")
+	fs.BoolVar(&opt.VerifyHostKey, "verify-host-key", false, "verify EC2 host key with console output before first connect")
+}
+
+func addMultiplexerFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.Tmux, "tmux", false, "open ssh to each chosen host in tmux window. (screen if tmux is not installed)")
+	fs.BoolVar(&opt.TmuxPanes, "tmux-panes", false, "with -tmux, open panes in one window instead of windows")
+	fs.BoolVar(&opt.TmuxSync, "tmux-sync", false, "with -tmux, open panes and synchronize input (synchronize-panes)")
+}
+
+func addWindowsFlags(fs *flag.FlagSet, opt *CommandOption) {
+	fs.BoolVar(&opt.CopyPassword, "copy-password", false, "copy windows administrator password to clipboard instead of print")
+}
+
+func loadChoosableList(rOpt *RnsshOption, handler *EC2Handler) ([]peco.Choosable, error) {
+	if rOpt.UseSshConfig {
+		choosableList, err := LoadSshConfigChoosableList()
+		if err != nil {
+			return nil, err
+		}
+
+		if len(choosableList) == 0 {
+			return nil, fmt.Errorf("ssh config does not have host settings")
+		}
+
+		return choosableList, nil
+	}
+
+	hostType := HOST_TYPE_PUBLIC_IP
+	if rOpt.HostType != "" {
+		hostType = rOpt.HostType
+	}
+
+	choosableList, err := handler.LoadTargetHost(hostType, rOpt.NetworkInterface, rOpt.Region, rOpt.Reload)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(choosableList) == 0 {
+		return nil, fmt.Errorf("there is no instance. not running %s", rOpt.Region)
+	}
+
+	return choosableList, nil
+}
+
+// chooseTargetHost shows hosts and returns chosen host and ssh user that specified by user@ format.
+func chooseTargetHost(selector Selector, choosableList []peco.Choosable, cmdArgs []string) (peco.Choosable, string, error) {
+	targetHosts, sshUser, err := chooseTargetHosts(selector, choosableList, cmdArgs)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// single host connects to the last one. (other chosen hosts are ignored)
+	targetHost := targetHosts[len(targetHosts)-1]
+	if err := checkAvailable(targetHost); err != nil {
+		return nil, "", err
+	}
+
+	return targetHost, sshUser, nil
+}
+
+// checkAvailable returns error if the instance has no address of the host type.
+func checkAvailable(targetHost peco.Choosable) error {
+	if e, ok := targetHost.(*ChoosableEC2); ok && e.Unavailable != "" {
+		return fmt.Errorf("can not connect to %s (%s): %s", e.InstanceId, e.Name, e.Unavailable)
+	}
+
+	return nil
+}
+
+// chooseTargetHosts returns all chosen hosts. (multiple selection)
+func chooseTargetHosts(selector Selector, choosableList []peco.Choosable, cmdArgs []string) ([]peco.Choosable, string, error) {
+
+	// support user@host format
+	sshUser, hostname, err := getSshUserAndHostname(strings.Join(cmdArgs, " "))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// show ec2 instances and choose intactive
+	targetHosts, err := selector.Choose("server", "which servers connect with ssh?", hostname, choosableList)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(targetHosts) == 0 {
+		return nil, "", fmt.Errorf("no select server.")
+	}
+
+	return targetHosts, sshUser, nil
+}
+
+func genSshArgsForHost(rOpt *RnsshOption, targetHost peco.Choosable, sshUser string) ([]string, error) {
+	sshUser, identityFile, sshOptions, err := resolveSshSettings(rOpt, targetHost, sshUser)
+	if err != nil {
+		return nil, err
+	}
+
+	sshHost := targetHost.Value()
+	sshArgs := genSshArgs(rOpt.SshUser, identityFile, rOpt.Port, rOpt.StrictHostKeyCheckingNo, sshOptions, sshUser, sshHost)
+
+	return sshArgs, nil
+}
+
+// resolveSshSettings returns ssh user, identity file and ssh options for the host.
+func resolveSshSettings(rOpt *RnsshOption, targetHost peco.Choosable, sshUser string) (string, string, []string, error) {
+	var sshOptions []string
+	if rOpt.CertificateFile != "" {
+		sshOptions = append(sshOptions, "CertificateFile="+rOpt.CertificateFile)
+	}
+
+	if rOpt.AgentSocket != "" {
+		sshOptions = append(sshOptions, "IdentityAgent="+rOpt.AgentSocket)
+	}
+
+	identityFile := rOpt.IdentityFile
+	if e, ok := targetHost.(*ChoosableEC2); ok {
+		if e.TargetType == HOST_TYPE_SSM {
+			sshOptions = append(sshOptions, genSsmProxyCommandOption(rOpt.Region))
+		}
+
+		// name host type is for ssh config, so it has User setting.
+		if rOpt.SshUser == "" && sshUser == "" && e.TargetType != HOST_TYPE_NAME_TAG {
+			sshUser = DetectSshUser(e, rOpt.SshUserTag, rOpt.SshUserByAMI)
+		}
+
+		var err error
+		identityFile, err = ResolveIdentityFile(e, rOpt.IdentityFile, rOpt.IdentityFileTemplate, rOpt.IdentityFileByKeyName)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		// StrictHostKeyChecking=no uses /dev/null known_hosts.
+		if rOpt.KnownHostsFile != "" && rOpt.StrictHostKeyCheckingNo != 1 {
+			sshOptions = append(sshOptions, knownHostsOptions(rOpt.KnownHostsFile, e.InstanceId)...)
+		}
+
+		// fail if host key is not the same as console output.
+		if rOpt.VerifyHostKey {
+			sshOptions = append(sshOptions, "StrictHostKeyChecking=yes")
+		}
+	}
+
+	// the key in agent is used without -i.
+	if identityFile != "" && identityFile == rOpt.IdentityFileInAgent {
+		identityFile = ""
+	}
+
+	return sshUser, identityFile, sshOptions, nil
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/peco"
+)
+
+// scriptedSelector chooses the item that Choice() contains Pick instead of interactive selector.
+type scriptedSelector struct {
+	Pick string
+
+	// shown query and choices
+	Query   string
+	Choices []string
+}
+
+func (s *scriptedSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	s.Query = defaultQuery
+	for _, c := range choices {
+		s.Choices = append(s.Choices, c.Choice())
+	}
+
+	for _, c := range choices {
+		if strings.Contains(c.Choice(), s.Pick) {
+			return []peco.Choosable{c}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no select %s.", itemName)
+}
+
+type commandRecorder struct {
+	Name string
+	Args []string
+}
+
+func (r *commandRecorder) Run(name string, args ...string) error {
+	r.Name = name
+	r.Args = args
+	return nil
+}
+
+type testApp struct {
+	*App
+	Out      *bytes.Buffer
+	Selector *scriptedSelector
+	EC2      *fakeEC2
+	Recorder *commandRecorder
+}
+
+func newTestApp(t *testing.T, pick string, config string, instances ...types.Instance) *testApp {
+	t.Helper()
+
+	for _, name := range ConfigEnvNames() {
+		t.Setenv(name, "")
+	}
+
+	dir := t.TempDir()
+	if config != "" {
+		if err := os.WriteFile(filepath.Join(dir, "config"), []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	sel := &scriptedSelector{Pick: pick}
+	f := &fakeEC2{Instances: instances}
+	rec := &commandRecorder{}
+
+	a := &App{
+		Stdin:    strings.NewReader(""),
+		Stdout:   out,
+		Stderr:   out,
+		RnsshDir: dir,
+		Selector: sel,
+		NewEC2Client: func(region, endpoint string) (EC2API, error) {
+			return f, nil
+		},
+		RunCommand: rec.Run,
+		LookPath: func(file string) (string, error) {
+			return "/usr/bin/" + file, nil
+		},
+	}
+
+	return &testApp{App: a, Out: out, Selector: sel, EC2: f, Recorder: rec}
+}
+
+func TestAppRun(t *testing.T) {
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"), withPrivateIP("10.0.0.1")),
+		newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2"), withPrivateIP("10.0.0.2")),
+		newFakeInstance("i-0003", "db1", withPrivateIP("10.0.0.3"), withTag("SshUser", "postgres")),
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		config   string
+		pick     string
+		code     int
+		query    string
+		sshArgs  []string
+		contains string
+	}{
+		{
+			name:    "public ip",
+			args:    []string{"-r", "ap-northeast-1", "web"},
+			pick:    "web2",
+			query:   "web",
+			sshArgs: []string{"203.0.113.2"},
+		},
+		{
+			name:    "private ip with user@ and ssh options",
+			args:    []string{"-r", "ap-northeast-1", "-p", "-port", "2222", "-strict-host-key-checking-no", "1", "admin@web"},
+			pick:    "web1",
+			query:   "web",
+			sshArgs: []string{"-p2222", "-oStrictHostKeyChecking=no", "-oUserKnownHostsFile=/dev/null", "admin@10.0.0.1"},
+		},
+		{
+			name:    "region and host type from config",
+			args:    []string{},
+			config:  "[Default]\n  aws_region = \"ap-northeast-1\"\n  host_type = \"public,private\"\n",
+			pick:    "db1",
+			sshArgs: []string{"postgres@10.0.0.3"},
+		},
+		{
+			name:    "-l is prior to instance tag",
+			args:    []string{"-r", "ap-northeast-1", "-p", "-l", "ec2-user"},
+			pick:    "db1",
+			sshArgs: []string{"-lec2-user", "10.0.0.3"},
+		},
+		{
+			name:     "unavailable instance",
+			args:     []string{"-r", "ap-northeast-1"},
+			pick:     "db1",
+			code:     1,
+			contains: "can not connect to i-0003 (db1): no public address",
+		},
+		{
+			name:     "no region",
+			args:     []string{},
+			code:     1,
+			contains: "region is empty",
+		},
+		{
+			name:     "duplicate host type option",
+			args:     []string{"-r", "ap-northeast-1", "-p", "-P"},
+			code:     1,
+			contains: "duplicate specify option",
+		},
+		{
+			name:     "show command",
+			args:     []string{"-r", "ap-northeast-1", "-s"},
+			pick:     "web1",
+			contains: "ssh 203.0.113.1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, tt.pick, tt.config, instances...)
+
+			code := a.Run(tt.args)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d but %d: %s", tt.code, code, a.Out.String())
+			}
+
+			if a.Selector.Query != tt.query {
+				t.Errorf("expected query %q but %q", tt.query, a.Selector.Query)
+			}
+
+			if tt.sshArgs != nil {
+				if a.Recorder.Name != "ssh" {
+					t.Fatalf("expected ssh but %q", a.Recorder.Name)
+				}
+
+				if !reflect.DeepEqual(a.Recorder.Args, tt.sshArgs) {
+					t.Errorf("expected ssh args %v but %v", tt.sshArgs, a.Recorder.Args)
+				}
+			}
+
+			if !strings.Contains(a.Out.String(), tt.contains) {
+				t.Errorf("expected output contains %q but %q", tt.contains, a.Out.String())
+			}
+		})
+	}
+}
+
+func TestAppRunSsm(t *testing.T) {
+	a := newTestApp(t, "web1", "", newFakeInstance("i-0001", "web1", withPrivateIP("10.0.0.1")))
+
+	if code := a.Run([]string{"-r", "us-east-1", "-l", "ec2-user"}); code != 1 {
+		t.Fatalf("expected exit code 1 but %d", code)
+	}
+
+	a = newTestApp(t, "web1", "[Default]\n  host_type = \"public,ssm\"\n", newFakeInstance("i-0001", "web1", withPrivateIP("10.0.0.1")))
+	if code := a.Run([]string{"-r", "us-east-1", "-l", "ec2-user"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	expected := []string{"-lec2-user", "-o" + genSsmProxyCommandOption("us-east-1"), "i-0001"}
+	if !reflect.DeepEqual(a.Recorder.Args, expected) {
+		t.Errorf("expected ssh args %v but %v", expected, a.Recorder.Args)
+	}
+}
+
+func TestAppRunDescribe(t *testing.T) {
+	i := newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"), withTag("Role", "frontend"))
+	i.InstanceType = types.InstanceTypeT3Micro
+	i.KeyName = aws.String("deploy-key")
+	a := newTestApp(t, "web1", "", i)
+
+	// no cache
+	if code := a.Run([]string{"-r", "ap-northeast-1", "-describe", "i-0001"}); code != 1 {
+		t.Fatalf("expected exit code 1 but %d", code)
+	}
+
+	if code := a.Run([]string{"-r", "ap-northeast-1", "-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-r", "ap-northeast-1", "-describe", "i-0001"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	for _, s := range []string{"i-0001", "t3.micro", "deploy-key", "Role", "frontend"} {
+		if !strings.Contains(a.Out.String(), s) {
+			t.Errorf("expected describe contains %q but %q", s, a.Out.String())
+		}
+	}
+
+	if a.EC2.DescribeInstancesCount != 1 {
+		t.Errorf("expected describe does not connect to AWS but DescribeInstances %d times", a.EC2.DescribeInstancesCount)
+	}
+}
+
+func TestGenPreviewCommand(t *testing.T) {
+	cmd := genPreviewCommand(&RnsshOption{Region: "ap-northeast-1"})
+	if !strings.HasSuffix(cmd, " -describe {1} -no-trust-prompt -r 'ap-northeast-1'") {
+		t.Errorf("unexpected preview command %q", cmd)
+	}
+
+	cmd = genPreviewCommand(&RnsshOption{Region: "ap-northeast-1", Profile: "prod", EC2Endpoint: "http://localhost:4566"})
+	if !strings.HasSuffix(cmd, " -r 'ap-northeast-1' -profile 'prod' -ec2-endpoint 'http://localhost:4566'") {
+		t.Errorf("expected preview command with profile and endpoint but %q", cmd)
+	}
+}
+
+func TestAppRunCommands(t *testing.T) {
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"), withPrivateIP("10.0.0.1")),
+		newFakeInstance("i-0002", "db1", withPrivateIP("10.0.0.2"), withTag("SshUser", "postgres")),
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		pick     string
+		code     int
+		command  string
+		cmdArgs  []string
+		contains string
+	}{
+		{
+			name:    "ssh",
+			args:    []string{"ssh", "-r", "ap-northeast-1", "web"},
+			pick:    "web1",
+			command: "ssh",
+			cmdArgs: []string{"203.0.113.1"},
+		},
+		{
+			name:    "exec",
+			args:    []string{"exec", "-r", "ap-northeast-1", "-p", "db", "--", "uptime", "-p"},
+			pick:    "db1",
+			command: "ssh",
+			cmdArgs: []string{"postgres@10.0.0.2", "uptime", "-p"},
+		},
+		{
+			name:     "exec without command",
+			args:     []string{"exec", "-r", "ap-northeast-1", "db"},
+			code:     1,
+			contains: "command is empty",
+		},
+		{
+			name:     "list",
+			args:     []string{"list", "-r", "ap-northeast-1", "-p", "db"},
+			contains: "i-0002",
+		},
+		{
+			name:    "cp download",
+			args:    []string{"cp", "-r", "ap-northeast-1", "-p", "-port", "2222", "db:/var/log/app.log", "."},
+			pick:    "db1",
+			command: "scp",
+			cmdArgs: []string{"-P2222", "postgres@10.0.0.2:/var/log/app.log", "."},
+		},
+		{
+			name:    "cp upload",
+			args:    []string{"cp", "-r", "ap-northeast-1", "-l", "admin", "-R", "./dir", "web:/tmp/"},
+			pick:    "web1",
+			command: "scp",
+			cmdArgs: []string{"-r", "./dir", "admin@203.0.113.1:/tmp/"},
+		},
+		{
+			name:     "cp without remote",
+			args:     []string{"cp", "-r", "ap-northeast-1", "./a", "./b"},
+			code:     1,
+			contains: "remote path is not found",
+		},
+		{
+			name:    "tunnel",
+			args:    []string{"tunnel", "-r", "ap-northeast-1", "-L", "db.internal:5432", "-L", "8080:localhost:80", "web"},
+			pick:    "web1",
+			command: "ssh",
+			cmdArgs: []string{"-N", "-L5432:db.internal:5432", "-L8080:localhost:80", "203.0.113.1"},
+		},
+		{
+			name:     "command help",
+			args:     []string{"tunnel", "-h"},
+			contains: "-L [local_port:]remote_host:remote_port",
+		},
+		{
+			name:     "usage",
+			args:     []string{"-h"},
+			contains: "exec      run command on the chosen host with ssh.",
+		},
+		{
+			name:     "usage lists environment variables",
+			args:     []string{"-h"},
+			contains: "RNSSH_ENV_OVERRIDE",
+		},
+		{
+			name:     "help",
+			args:     []string{"help", "exec"},
+			contains: "usage: rnssh exec [options] [user@]query ... -- command [args ...]",
+		},
+		{
+			name:     "list with query after --",
+			args:     []string{"list", "-r", "ap-northeast-1", "--", "db"},
+			contains: "i-0002",
+		},
+		{
+			name:     "args after -- for ssh",
+			args:     []string{"ssh", "-l", "admin", "web", "--", "uptime"},
+			code:     1,
+			contains: "does not accept args after --",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, tt.pick, "", instances...)
+
+			code := a.Run(tt.args)
+			if code != tt.code {
+				t.Fatalf("expected exit code %d but %d: %s", tt.code, code, a.Out.String())
+			}
+
+			if a.Recorder.Name != tt.command {
+				t.Fatalf("expected command %q but %q", tt.command, a.Recorder.Name)
+			}
+
+			if tt.cmdArgs != nil && !reflect.DeepEqual(a.Recorder.Args, tt.cmdArgs) {
+				t.Errorf("expected args %v but %v", tt.cmdArgs, a.Recorder.Args)
+			}
+
+			if !strings.Contains(a.Out.String(), tt.contains) {
+				t.Errorf("expected output contains %q but %q", tt.contains, a.Out.String())
+			}
+		})
+	}
+}
+
+func TestAppRunLegacyQuery(t *testing.T) {
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+		newFakeInstance("i-0002", "cache1", withPublicIP("203.0.113.2")),
+		newFakeInstance("i-0003", "lister1", withPublicIP("203.0.113.3")),
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		pick    string
+		query   string
+		sshArgs []string
+	}{
+		{
+			name:    "query",
+			args:    []string{"web"},
+			pick:    "web1",
+			query:   "web",
+			sshArgs: []string{"203.0.113.1"},
+		},
+		{
+			name:    "command name",
+			args:    []string{"cache"},
+			pick:    "cache1",
+			query:   "cache",
+			sshArgs: []string{"203.0.113.2"},
+		},
+		{
+			name:    "command name without action",
+			args:    []string{"list", "web"},
+			pick:    "lister1",
+			query:   "list web",
+			sshArgs: []string{"203.0.113.3"},
+		},
+		{
+			name:    "command name without remote path",
+			args:    []string{"cp", "web"},
+			pick:    "web1",
+			query:   "cp web",
+			sshArgs: []string{"203.0.113.1"},
+		},
+		{
+			name:    "help without command",
+			args:    []string{"help"},
+			pick:    "web1",
+			query:   "help",
+			sshArgs: []string{"203.0.113.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestApp(t, tt.pick, "[Default]\n  aws_region = \"ap-northeast-1\"\n", instances...)
+
+			if code := a.Run(tt.args); code != 0 {
+				t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+			}
+
+			if a.Selector.Query != tt.query {
+				t.Errorf("expected query %q but %q", tt.query, a.Selector.Query)
+			}
+
+			if a.Recorder.Name != "ssh" || !reflect.DeepEqual(a.Recorder.Args, tt.sshArgs) {
+				t.Errorf("expected ssh %v but %s %v", tt.sshArgs, a.Recorder.Name, a.Recorder.Args)
+			}
+
+			// command name query shows how to run the command.
+			hint := "hint: " + tt.args[0] + " is used as query."
+			if isCommand := findCommand(tt.args[0]) != nil; isCommand != strings.Contains(a.Out.String(), hint) {
+				t.Errorf("expected hint %v but %q", isCommand, a.Out.String())
+			}
+		})
+	}
+
+	a := newTestApp(t, "lister1", "[Default]\n  aws_region = \"ap-northeast-1\"\n", instances...)
+	if code := a.Run([]string{"list", "web"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "(ex: rnssh list -- web)") {
+		t.Errorf("expected hint with example but %q", a.Out.String())
+	}
+}
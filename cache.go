@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reiki4040/cstore"
+)
+
+// ErrCacheVersion is returned when the cache is old format.
+var ErrCacheVersion = errors.New("cache format is old")
+
+// CacheInfo is summary of instances cache file.
+type CacheInfo struct {
+	Region  string
+	Path    string
+	Version int
+	Count   int
+	Size    int64
+	ModTime time.Time
+}
+
+func (c *CacheInfo) Age() time.Duration {
+	return time.Since(c.ModTime).Round(time.Second)
+}
+
+func cacheFilePath(rnsshDir, region string) string {
+	return filepath.Join(rnsshDir, RNSSH_EC2_LIST_CACHE_PREFIX+region+".json")
+}
+
+// SaveCache writes cache to temp file and renames it.
+// so reader does not see half-written cache even if other rnssh is writing.
+func SaveCache(path string, is *Instances) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(is)
+	})
+}
+
+// writeFileAtomic writes to temp file in the same dir and renames it to path.
+// if path is symlink, it writes to the link target. (ex: ~/.rnssh/config in dotfiles repo)
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		path = p
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// lockCache takes advisory lock of the cache. (wait until other rnssh unlocks)
+// returned func unlocks it.
+func lockCache(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// cacheFiles returns region -> cache file path.
+func cacheFiles(rnsshDir string) (map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(rnsshDir, RNSSH_EC2_LIST_CACHE_PREFIX+"*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make(map[string]string, len(files))
+	for _, f := range files {
+		region := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), RNSSH_EC2_LIST_CACHE_PREFIX), ".json")
+		regions[region] = f
+	}
+
+	return regions, nil
+}
+
+// LoadCacheInfo reads the cache file. count is 0 if the cache is old format.
+func LoadCacheInfo(region, path string) (*CacheInfo, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &CacheInfo{Region: region, Path: path, Size: st.Size(), ModTime: st.ModTime()}
+
+	is := Instances{}
+	if err := cstore.LoadFromJsonFile(path, &is); err != nil {
+		return nil, fmt.Errorf("can not read cache %s: %s", path, err.Error())
+	}
+
+	info.Version = is.Version
+	if is.Version == INSTANCES_CACHE_VERSION {
+		info.Count = len(is.Instances)
+	}
+
+	return info, nil
+}
+
+// ListCacheInfo returns all region cache info order by region.
+func ListCacheInfo(rnsshDir string) ([]*CacheInfo, error) {
+	files, err := cacheFiles(rnsshDir)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*CacheInfo, 0, len(files))
+	for region, path := range files {
+		info, err := LoadCacheInfo(region, path)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Region < infos[j].Region })
+
+	return infos, nil
+}
+
+// ClearCache removes cache file (and its lock file) of the region. empty region removes all cache files.
+func ClearCache(rnsshDir, region string) ([]string, error) {
+	files, err := cacheFiles(rnsshDir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for r, path := range files {
+		if region != "" && r != region {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("can not remove cache %s: %s", path, err.Error())
+		}
+		removed = append(removed, path)
+
+		if err := os.Remove(path + ".lock"); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("can not remove cache lock %s: %s", path+".lock", err.Error())
+		}
+	}
+	sort.Strings(removed)
+
+	return removed, nil
+}
+
+func WriteCacheList(w io.Writer, infos []*CacheInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REGION\tINSTANCES\tAGE\tSIZE\tVERSION")
+	for _, c := range infos {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Region, cacheCount(c), c.Age(), formatSize(c.Size), cacheVersion(c))
+	}
+	tw.Flush()
+}
+
+func WriteCacheInfo(w io.Writer, c *CacheInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Region:\t%s\n", c.Region)
+	fmt.Fprintf(tw, "Path:\t%s\n", c.Path)
+	fmt.Fprintf(tw, "Instances:\t%s\n", cacheCount(c))
+	fmt.Fprintf(tw, "CachedAt:\t%s\n", c.ModTime.Local().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Age:\t%s\n", c.Age())
+	fmt.Fprintf(tw, "Size:\t%s\n", formatSize(c.Size))
+	fmt.Fprintf(tw, "Version:\t%s\n", cacheVersion(c))
+	tw.Flush()
+}
+
+func cacheCount(c *CacheInfo) string {
+	if c.Version != INSTANCES_CACHE_VERSION {
+		return "-"
+	}
+
+	return fmt.Sprint(c.Count)
+}
+
+func cacheVersion(c *CacheInfo) string {
+	if c.Version != INSTANCES_CACHE_VERSION {
+		return fmt.Sprintf("%d (old, reload at next use)", c.Version)
+	}
+
+	return fmt.Sprint(c.Version)
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f%cB", float64(size)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/cstore"
+)
+
+func TestAppRunCache(t *testing.T) {
+	a := newTestApp(t, "", "", newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+
+	for _, region := range []string{"ap-northeast-1", "us-east-1"} {
+		if code := a.Run([]string{"cache", "refresh", "-r", region}); code != 0 {
+			t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+		}
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"cache", "ls"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(a.Out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "ap-northeast-1  1 ") || !strings.HasPrefix(lines[2], "us-east-1       1 ") {
+		t.Errorf("unexpected cache list: %q", a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"cache", "info", "-r", "us-east-1"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	for _, s := range []string{"Region:     us-east-1", "Instances:  1", cacheFilePath(a.RnsshDir, "us-east-1")} {
+		if !strings.Contains(a.Out.String(), s) {
+			t.Errorf("expected info contains %q but %q", s, a.Out.String())
+		}
+	}
+
+	if code := a.Run([]string{"cache", "clear", "-r", "us-east-1"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if _, err := os.Stat(cacheFilePath(a.RnsshDir, "us-east-1")); !os.IsNotExist(err) {
+		t.Errorf("expected us-east-1 cache is removed but %v", err)
+	}
+
+	if _, err := os.Stat(cacheFilePath(a.RnsshDir, "us-east-1") + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("expected us-east-1 cache lock is removed but %v", err)
+	}
+
+	if _, err := os.Stat(cacheFilePath(a.RnsshDir, "ap-northeast-1")); err != nil {
+		t.Errorf("expected ap-northeast-1 cache exists but %v", err)
+	}
+
+	if code := a.Run([]string{"cache", "clear"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if files, _ := cacheFiles(a.RnsshDir); len(files) != 0 {
+		t.Errorf("expected all cache is removed but %v", files)
+	}
+
+	if locks, _ := filepath.Glob(filepath.Join(a.RnsshDir, "*.lock")); len(locks) != 0 {
+		t.Errorf("expected all cache lock is removed but %v", locks)
+	}
+}
+
+func TestAppRunCacheRefreshNoInstance(t *testing.T) {
+	a := newTestApp(t, "", "")
+
+	if code := a.Run([]string{"cache", "refresh", "-r", "eu-west-1"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "cached 0 instances in eu-west-1.") {
+		t.Errorf("unexpected output: %q", a.Out.String())
+	}
+
+	if _, err := os.Stat(cacheFilePath(a.RnsshDir, "eu-west-1")); err != nil {
+		t.Errorf("expected empty cache is written but %v", err)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		512:             "512B",
+		2048:            "2.0KB",
+		5 * 1024 * 1024: "5.0MB",
+	}
+
+	for size, expected := range tests {
+		if actual := formatSize(size); actual != expected {
+			t.Errorf("%d: expected %s but %s", size, expected, actual)
+		}
+	}
+}
+
+// cache format before version 2. (full SDK instances)
+type sdkInstancesCache struct {
+	Instances  []*types.Instance `json:"ec2_instances"`
+	ImageNames map[string]string `json:"ec2_image_names,omitempty"`
+}
+
+// benchmark instance has fields that DescribeInstances returns typically.
+func newBenchInstance(n int) types.Instance {
+	ip := fmt.Sprintf("10.0.%d.%d", n/256%256, n%256)
+	i := newFakeInstance(fmt.Sprintf("i-%017d", n), fmt.Sprintf("app-%d", n),
+		withPrivateIP(ip),
+		withPublicIP(fmt.Sprintf("203.0.%d.%d", n/256%256, n%256)),
+		withTag("Role", "app"),
+		withTag("Environment", "production"),
+		withTag("Team", "platform"),
+		withTag("aws:autoscaling:groupName", "app-asg"),
+		withNetworkInterface(0, "subnet-0123456789abcdef0", "", ip),
+	)
+
+	i.ImageId = aws.String("ami-0123456789abcdef0")
+	i.KeyName = aws.String("deploy-key")
+	i.InstanceType = types.InstanceTypeM5Large
+	i.PlatformDetails = aws.String("Linux/UNIX")
+	i.VpcId = aws.String("vpc-0123456789abcdef0")
+	i.SubnetId = aws.String("subnet-0123456789abcdef0")
+	i.LaunchTime = aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	i.Placement = &types.Placement{AvailabilityZone: aws.String("ap-northeast-1a"), Tenancy: types.TenancyDefault}
+	i.SecurityGroups = []types.GroupIdentifier{{GroupId: aws.String("sg-0123456789abcdef0"), GroupName: aws.String("app")}}
+	i.Architecture = types.ArchitectureValuesX8664
+	i.RootDeviceName = aws.String("/dev/xvda")
+	i.PrivateDnsName = aws.String("ip-" + ip + ".ap-northeast-1.compute.internal")
+	i.BlockDeviceMappings = []types.InstanceBlockDeviceMapping{{
+		DeviceName: aws.String("/dev/xvda"),
+		Ebs: &types.EbsInstanceBlockDevice{
+			VolumeId:            aws.String("vol-0123456789abcdef0"),
+			AttachTime:          aws.Time(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+			DeleteOnTermination: aws.Bool(true),
+			Status:              types.AttachmentStatusAttached,
+		},
+	}}
+	i.MetadataOptions = &types.InstanceMetadataOptionsResponse{HttpTokens: types.HttpTokensStateRequired, HttpPutResponseHopLimit: aws.Int32(2)}
+	i.CpuOptions = &types.CpuOptions{CoreCount: aws.Int32(1), ThreadsPerCore: aws.Int32(2)}
+	i.Monitoring = &types.Monitoring{State: types.MonitoringStateDisabled}
+
+	return i
+}
+
+func BenchmarkLoadCache(b *testing.B) {
+	const count = 5000
+	instances := make([]*types.Instance, 0, count)
+	for n := 0; n < count; n++ {
+		i := newBenchInstance(n)
+		instances = append(instances, &i)
+	}
+
+	dir := b.TempDir()
+	sdkPath := filepath.Join(dir, "sdk.json")
+	if err := cstore.StoreToJsonFile(sdkPath, &sdkInstancesCache{Instances: instances}); err != nil {
+		b.Fatal(err)
+	}
+
+	cachedPath := filepath.Join(dir, "cached.json")
+	if err := cstore.StoreToJsonFile(cachedPath, &Instances{Version: INSTANCES_CACHE_VERSION, Instances: NewCachedInstances(instances)}); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("sdk instances", func(b *testing.B) {
+		reportFileSize(b, sdkPath)
+		for n := 0; n < b.N; n++ {
+			is := sdkInstancesCache{}
+			if err := cstore.LoadFromJsonFile(sdkPath, &is); err != nil {
+				b.Fatal(err)
+			}
+			ConvertChoosableList(NewCachedInstances(is.Instances), is.ImageNames, HOST_TYPE_PUBLIC_IP, "")
+		}
+	})
+
+	b.Run("cached instances", func(b *testing.B) {
+		reportFileSize(b, cachedPath)
+		for n := 0; n < b.N; n++ {
+			is := Instances{}
+			if err := cstore.LoadFromJsonFile(cachedPath, &is); err != nil {
+				b.Fatal(err)
+			}
+			ConvertChoosableList(is.Instances, is.ImageNames, HOST_TYPE_PUBLIC_IP, "")
+		}
+	})
+}
+
+func reportFileSize(b *testing.B, path string) {
+	st, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportMetric(float64(st.Size()), "file-bytes")
+}
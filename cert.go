@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	CERTS_DIR_NAME = "certs"
+
+	// certificate file name of no profile. (default-cert.pub)
+	DEFAULT_CERT_PROFILE = "default"
+
+	// certificate that expires within this is renewed before connect.
+	CERT_RENEW_BEFORE = time.Minute
+
+	CERT_SIGNER_TIMEOUT = 30 * time.Second
+)
+
+// number of public key fields in certificate after nonce. (ex: ssh-ed25519 has pk)
+var certKeyFields = map[string]int{
+	"ssh-rsa-cert-v01@openssh.com":                2,
+	"ssh-dss-cert-v01@openssh.com":                4,
+	"ecdsa-sha2-nistp256-cert-v01@openssh.com":    2,
+	"ecdsa-sha2-nistp384-cert-v01@openssh.com":    2,
+	"ecdsa-sha2-nistp521-cert-v01@openssh.com":    2,
+	"ssh-ed25519-cert-v01@openssh.com":            1,
+	"sk-ecdsa-sha2-nistp256-cert-v01@openssh.com": 3,
+	"sk-ssh-ed25519-cert-v01@openssh.com":         2,
+}
+
+// SshCertificate is OpenSSH user certificate.
+type SshCertificate struct {
+	Type        string
+	KeyId       string
+	Principals  []string
+	ValidAfter  time.Time
+	ValidBefore time.Time
+
+	// valid_before is infinity.
+	Forever bool
+}
+
+// Expired returns true if the certificate expires before now + d.
+func (c *SshCertificate) Expired(now time.Time, d time.Duration) bool {
+	if c.Forever {
+		return false
+	}
+
+	return !now.Add(d).Before(c.ValidBefore)
+}
+
+func (c *SshCertificate) String() string {
+	before := "forever"
+	if !c.Forever {
+		before = c.ValidBefore.Format(time.RFC3339)
+	}
+
+	return fmt.Sprintf("key id %q, principals %s, valid %s to %s", c.KeyId, strings.Join(c.Principals, ","), c.ValidAfter.Format(time.RFC3339), before)
+}
+
+// certReader reads ssh wire format (RFC 4251).
+type certReader struct {
+	b   []byte
+	err error
+}
+
+func (r *certReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	if len(r.b) < 4 {
+		r.err = fmt.Errorf("unexpected end of certificate")
+		return nil
+	}
+
+	l := binary.BigEndian.Uint32(r.b)
+	if uint64(len(r.b)-4) < uint64(l) {
+		r.err = fmt.Errorf("unexpected end of certificate")
+		return nil
+	}
+
+	v := r.b[4 : 4+l]
+	r.b = r.b[4+l:]
+	return v
+}
+
+func (r *certReader) uint64() uint64 {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.b) < 8 {
+		r.err = fmt.Errorf("unexpected end of certificate")
+		return 0
+	}
+
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *certReader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+
+	if len(r.b) < 4 {
+		r.err = fmt.Errorf("unexpected end of certificate")
+		return 0
+	}
+
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+// ParseCertificate parses certificate file content. ("type base64 [comment]")
+func ParseCertificate(content string) (*SshCertificate, error) {
+	fields := strings.Fields(content)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("invalid certificate format")
+	}
+
+	n, ok := certKeyFields[fields[0]]
+	if !ok {
+		return nil, fmt.Errorf("not certificate: %s", fields[0])
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate: %s", err.Error())
+	}
+
+	r := &certReader{b: blob}
+	certType := string(r.bytes())
+	if r.err == nil && certType != fields[0] {
+		return nil, fmt.Errorf("invalid certificate: key type is not matched")
+	}
+
+	// nonce and public key
+	for i := 0; i < n+1; i++ {
+		r.bytes()
+	}
+
+	// serial, type (1: user, 2: host)
+	r.uint64()
+	r.uint32()
+
+	c := &SshCertificate{Type: certType, KeyId: string(r.bytes())}
+	pr := &certReader{b: r.bytes()}
+	validAfter := r.uint64()
+	validBefore := r.uint64()
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid certificate: %s", r.err.Error())
+	}
+
+	for len(pr.b) > 0 {
+		c.Principals = append(c.Principals, string(pr.bytes()))
+	}
+	if pr.err != nil {
+		return nil, fmt.Errorf("invalid certificate principals: %s", pr.err.Error())
+	}
+
+	c.ValidAfter = time.Unix(int64(min(validAfter, math.MaxInt64)), 0)
+	if validBefore > math.MaxInt64 {
+		c.Forever = true
+	} else {
+		c.ValidBefore = time.Unix(int64(validBefore), 0)
+	}
+
+	return c, nil
+}
+
+// ReadCertificate reads certificate file.
+func ReadCertificate(path string) (*SshCertificate, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := ParseCertificate(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+
+	return c, nil
+}
+
+// certPath returns certificate path of the profile. ~/.rnssh/certs/<profile>-cert.pub
+func certPath(rnsshDir, profile string) string {
+	if profile == "" {
+		profile = DEFAULT_CERT_PROFILE
+	}
+
+	return filepath.Join(rnsshDir, CERTS_DIR_NAME, profile+"-cert.pub")
+}
+
+// values for ssh_cert_sign_command. ex: my-signer -pub {{.PublicKeyFile}} -out {{.CertFile}}
+type CertSignCommandValues struct {
+	PublicKeyFile string
+	CertFile      string
+	Profile       string
+}
+
+func CertSignCommandCheck(c string) error {
+	if _, err := parseCertSignCommand(c); err != nil {
+		return fmt.Errorf("invalid CertSignCommand value: %s", err.Error())
+	}
+
+	return nil
+}
+
+// each field of command is template, same as rdp_command.
+func parseCertSignCommand(c string) ([]*template.Template, error) {
+	fields := strings.Fields(c)
+	templates := make([]*template.Template, 0, len(fields))
+	for _, f := range fields {
+		t, err := template.New("ssh_cert_sign_command").Option("missingkey=error").Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+func genCertSignCommand(c string, v CertSignCommandValues) ([]string, error) {
+	templates, err := parseCertSignCommand(c)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, len(templates))
+	for _, t := range templates {
+		var b bytes.Buffer
+		if err := t.Execute(&b, v); err != nil {
+			return nil, err
+		}
+		args = append(args, b.String())
+	}
+
+	return args, nil
+}
+
+func CertSignerURLCheck(u string) error {
+	if u == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid CertSignerURL value: %s. allow http(s) URL or \"\"(default)", u)
+	}
+
+	return nil
+}
+
+// request and response of HTTP signer.
+type certSignRequest struct {
+	PublicKey string `json:"public_key"`
+	Profile   string `json:"profile"`
+}
+
+type certSignResponse struct {
+	Certificate string `json:"certificate"`
+}
+
+// signCertificateHTTP posts public key to signer and returns certificate.
+func signCertificateHTTP(signerURL, publicKey, profile string) (string, error) {
+	body, err := json.Marshal(certSignRequest{PublicKey: strings.TrimSpace(publicKey), Profile: profile})
+	if err != nil {
+		return "", err
+	}
+
+	cli := &http.Client{Timeout: CERT_SIGNER_TIMEOUT}
+	resp, err := cli.Post(signerURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("signer returns %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var r certSignResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return "", fmt.Errorf("invalid signer response: %s", err.Error())
+	}
+
+	return r.Certificate, nil
+}
+
+// prepareCertificate renews the certificate of the profile if it is expired, and sets CertificateFile.
+// nothing to do if signer is not configured. with showCommand, it shows the current certificate without renewal.
+func (a *App) prepareCertificate(rOpt *RnsshOption, showCommand bool) error {
+	if rOpt.CertSignCommand == "" && rOpt.CertSignerURL == "" {
+		return nil
+	}
+
+	path := certPath(a.RnsshDir, rOpt.Profile)
+	cert, err := ReadCertificate(path)
+	renew := err != nil || cert.Expired(time.Now(), CERT_RENEW_BEFORE)
+	if showCommand {
+		switch {
+		case os.IsNotExist(err):
+			fmt.Fprintf(a.Stdout, "# certificate %s is not found. it is signed before connect\n", path)
+		case err != nil:
+			fmt.Fprintf(a.Stdout, "# certificate %s is invalid (%s). it is signed before connect\n", path, err.Error())
+		case renew:
+			fmt.Fprintf(a.Stdout, "# certificate %s: %s. it is expired and renewed before connect\n", path, cert.String())
+		default:
+			fmt.Fprintf(a.Stdout, "# certificate %s: %s\n", path, cert.String())
+		}
+		rOpt.CertificateFile = path
+		return nil
+	}
+
+	if renew {
+		if _, err := a.signCertificate(rOpt, path); err != nil {
+			return fmt.Errorf("can not renew ssh certificate: %s", err.Error())
+		}
+		fmt.Fprintf(a.Stderr, "renewed ssh certificate %s\n", path)
+	}
+
+	rOpt.CertificateFile = path
+	return nil
+}
+
+// signCertificate signs the public key with sign command or HTTP signer, and saves certificate to path.
+func (a *App) signCertificate(rOpt *RnsshOption, path string) (*SshCertificate, error) {
+	pubKeyFile := rOpt.CertPublicKey
+	if pubKeyFile == "" {
+		if rOpt.IdentityFile == "" {
+			return nil, fmt.Errorf("public key is unknown. please set ssh_cert_public_key or ssh_identity_file")
+		}
+		pubKeyFile = rOpt.IdentityFile + ".pub"
+	}
+
+	pubKeyFile, err := expandHomeDir(pubKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	// signed certificate is checked before replacing current one.
+	tmp := path + ".tmp"
+	defer os.Remove(tmp)
+
+	if rOpt.CertSignCommand != "" {
+		cmdArgs, err := genCertSignCommand(rOpt.CertSignCommand, CertSignCommandValues{PublicKeyFile: pubKeyFile, CertFile: tmp, Profile: rOpt.Profile})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(cmdArgs) == 0 {
+			return nil, fmt.Errorf("ssh_cert_sign_command is empty")
+		}
+
+		if err := a.RunCommand(cmdArgs[0], cmdArgs[1:]...); err != nil {
+			return nil, fmt.Errorf("sign command failed: %s", err.Error())
+		}
+	} else {
+		publicKey, err := os.ReadFile(pubKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		signed, err := signCertificateHTTP(rOpt.CertSignerURL, string(publicKey), rOpt.Profile)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.WriteFile(tmp, []byte(strings.TrimSpace(signed)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	cert, err := ReadCertificate(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Expired(time.Now(), 0) {
+		return nil, fmt.Errorf("signed certificate is already expired (%s)", cert.String())
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns ed25519 user certificate (not signed) for parser.
+func testCertificate(principals []string, validAfter, validBefore uint64) string {
+	var blob []byte
+	putString := func(s []byte) {
+		blob = binary.BigEndian.AppendUint32(blob, uint32(len(s)))
+		blob = append(blob, s...)
+	}
+
+	certType := "ssh-ed25519-cert-v01@openssh.com"
+	putString([]byte(certType))
+	putString([]byte("nonce"))
+	putString(make([]byte, 32))
+	blob = binary.BigEndian.AppendUint64(blob, 1)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	putString([]byte("alice@example.com"))
+
+	var packed []byte
+	for _, p := range principals {
+		packed = binary.BigEndian.AppendUint32(packed, uint32(len(p)))
+		packed = append(packed, p...)
+	}
+	putString(packed)
+	blob = binary.BigEndian.AppendUint64(blob, validAfter)
+	blob = binary.BigEndian.AppendUint64(blob, validBefore)
+
+	// critical options, extensions, reserved, signature key, signature
+	for i := 0; i < 5; i++ {
+		putString(nil)
+	}
+
+	return certType + " " + base64.StdEncoding.EncodeToString(blob) + " alice@example.com\n"
+}
+
+func TestParseCertificate(t *testing.T) {
+	now := time.Unix(1760000000, 0)
+	c, err := ParseCertificate(testCertificate([]string{"ec2-user", "admin"}, uint64(now.Unix()), uint64(now.Add(time.Hour).Unix())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.KeyId != "alice@example.com" || !reflect.DeepEqual(c.Principals, []string{"ec2-user", "admin"}) {
+		t.Errorf("unexpected certificate: %+v", c)
+	}
+
+	if !c.ValidAfter.Equal(now) || !c.ValidBefore.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected validity: %s", c.String())
+	}
+
+	if c.Expired(now, 30*time.Minute) || !c.Expired(now, time.Hour) {
+		t.Errorf("unexpected expired: %s", c.String())
+	}
+
+	forever, err := ParseCertificate(testCertificate(nil, 0, math.MaxUint64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !forever.Forever || forever.Expired(now, time.Hour) || !strings.Contains(forever.String(), "forever") {
+		t.Errorf("expected forever certificate but %s", forever.String())
+	}
+
+	if _, err := ParseCertificate("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIA== key"); err == nil {
+		t.Errorf("expected error of public key")
+	}
+}
+
+// newCertTestApp returns app that has identity file and public key.
+func newCertTestApp(t *testing.T, config string) (*testApp, string) {
+	t.Helper()
+
+	key := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(key, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key+".pub", []byte("ssh-ed25519 AAAA alice@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config = "[Default]\n  aws_region = \"ap-northeast-1\"\n  ssh_identity_file = \"" + key + "\"\n" + config
+	return newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"))), key
+}
+
+func TestAppRunCertSignCommand(t *testing.T) {
+	a, key := newCertTestApp(t, "  ssh_cert_sign_command = \"signer -pub {{.PublicKeyFile}} -out {{.CertFile}}\"\n")
+
+	signed := 0
+	validBefore := time.Now().Add(time.Hour)
+	a.RunCommand = func(name string, args ...string) error {
+		if name != "signer" {
+			return a.Recorder.Run(name, args...)
+		}
+
+		signed++
+		if args[1] != key+".pub" {
+			t.Errorf("unexpected public key: %v", args)
+		}
+
+		return os.WriteFile(args[3], []byte(testCertificate([]string{"ec2-user"}, 0, uint64(validBefore.Unix()))), 0600)
+	}
+
+	// show command does not sign.
+	path := certPath(a.RnsshDir, "")
+	if code := a.Run([]string{"-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	for _, s := range []string{"# certificate " + path + " is not found. it is signed before connect", "-oCertificateFile=" + path + " 203.0.113.1"} {
+		if !strings.Contains(a.Out.String(), s) {
+			t.Errorf("expected output contains %q but %q", s, a.Out.String())
+		}
+	}
+
+	if _, err := os.Stat(path); signed != 0 || !os.IsNotExist(err) {
+		t.Errorf("expected not signed with -s but signed %d: %v", signed, err)
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "renewed ssh certificate "+path) {
+		t.Errorf("expected renewed message but %q", a.Out.String())
+	}
+
+	// valid certificate is used without signing.
+	a.Out.Reset()
+	if code := a.Run([]string{"-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "# certificate "+path+": key id \"alice@example.com\", principals ec2-user, valid") {
+		t.Errorf("expected certificate principals but %q", a.Out.String())
+	}
+
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if signed != 1 {
+		t.Errorf("expected signed once but %d", signed)
+	}
+
+	expected := []string{"-i" + key, "-oCertificateFile=" + path, "203.0.113.1"}
+	if !reflect.DeepEqual(a.Recorder.Args, expected) {
+		t.Errorf("expected ssh args %v but %v", expected, a.Recorder.Args)
+	}
+
+	// expired certificate is renewed.
+	if err := os.WriteFile(path, []byte(testCertificate([]string{"ec2-user"}, 0, uint64(time.Now().Add(-time.Minute).Unix()))), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-s"}); code != 0 || signed != 1 || !strings.Contains(a.Out.String(), "it is expired and renewed before connect") {
+		t.Errorf("expected expired certificate is shown without renewal but %d %d: %q", code, signed, a.Out.String())
+	}
+
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if signed != 2 {
+		t.Errorf("expected expired certificate is renewed but signed %d", signed)
+	}
+}
+
+func TestAppRunCertSignerURL(t *testing.T) {
+	var req certSignRequest
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+
+		if status != http.StatusOK {
+			http.Error(w, "denied", status)
+			return
+		}
+
+		json.NewEncoder(w).Encode(certSignResponse{Certificate: testCertificate([]string{"ec2-user"}, 0, uint64(time.Now().Add(time.Hour).Unix()))})
+	}))
+	defer srv.Close()
+
+	a, _ := newCertTestApp(t, "\n[[profiles]]\n  profile_name = \"prod\"\n  ssh_cert_signer_url = \""+srv.URL+"\"\n")
+	if code := a.Run([]string{"-profile", "prod"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if req.PublicKey != "ssh-ed25519 AAAA alice@example.com" || req.Profile != "prod" {
+		t.Errorf("unexpected request: %+v", req)
+	}
+
+	path := certPath(a.RnsshDir, "prod")
+	if !strings.HasSuffix(path, filepath.Join("certs", "prod-cert.pub")) {
+		t.Errorf("unexpected certificate path: %s", path)
+	}
+
+	if a.Recorder.Args[1] != "-oCertificateFile="+path {
+		t.Errorf("expected certificate option but %v", a.Recorder.Args)
+	}
+
+	// signer error does not connect.
+	os.Remove(path)
+	status = http.StatusForbidden
+	a.Recorder.Name = ""
+	a.Out.Reset()
+	if code := a.Run([]string{"-profile", "prod"}); code != 1 {
+		t.Fatalf("expected exit code 1 but %d", code)
+	}
+
+	if a.Recorder.Name != "" || !strings.Contains(a.Out.String(), "can not renew ssh certificate: signer returns 403 Forbidden: denied") {
+		t.Errorf("unexpected result: %s %q", a.Recorder.Name, a.Out.String())
+	}
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Command is rnssh subcommand. help is generated from flag definitions.
+type Command struct {
+	Name     string
+	Args     string
+	Synopsis string
+
+	// command args after --. (ex: exec) empty is not allowed.
+	RemoteArgs string
+
+	// sub actions (ex: cache ls). options can be specified after the action.
+	Actions []string
+
+	// returns true if the args (without action and options) are for the command. (ex: cp remote path)
+	// otherwise "rnssh <command name> ..." is legacy style query.
+	Accepts func(args []string) bool
+
+	Flags func(fs *flag.FlagSet, opt *CommandOption)
+	Run   func(a *App, opt *CommandOption, args, remoteArgs []string) error
+}
+
+func (c *Command) newFlagSet(opt *CommandOption, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("rnssh "+c.Name, flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.Usage = func() { c.WriteUsage(output, fs) }
+
+	addHelpFlags(fs, opt)
+	if c.Flags != nil {
+		c.Flags(fs, opt)
+	}
+
+	return fs
+}
+
+// WriteUsage writes the command help.
+func (c *Command) WriteUsage(w io.Writer, fs *flag.FlagSet) {
+	args := "[options]"
+	if c.Args != "" {
+		args += " " + c.Args
+	}
+	if c.RemoteArgs != "" {
+		args += " -- " + c.RemoteArgs
+	}
+
+	fmt.Fprintf(w, "usage: rnssh %s %s\n\n", c.Name, args)
+	fmt.Fprintf(w, "%s\n\n", c.Synopsis)
+	fmt.Fprintln(w, "options:")
+	writeFlags(w, fs)
+}
+
+// commands returns rnssh subcommands. (function for avoiding initialization loop with help)
+func commands() []*Command {
+	return []*Command{
+		{
+			Name:     "ssh",
+			Args:     "[user@]query ...",
+			Synopsis: "ssh login to the chosen host. same as rnssh without command.",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				addTargetFlags(fs, opt)
+				addSelectorFlags(fs, opt)
+				addSshFlags(fs, opt)
+				addWindowsFlags(fs, opt)
+				addMultiplexerFlags(fs, opt)
+			},
+			Run: runSshCommand,
+		},
+		{
+			Name:       "exec",
+			Args:       "[user@]query ...",
+			RemoteArgs: "command [args ...]",
+			Synopsis:   "run command on the chosen host with ssh.",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				addTargetFlags(fs, opt)
+				addSelectorFlags(fs, opt)
+				addSshFlags(fs, opt)
+			},
+			Run: runExecCommand,
+		},
+		{
+			Name:     "list",
+			Args:     "[query ...]",
+			Synopsis: "list hosts. query filters hosts that include all words.",
+			Flags:    addTargetFlags,
+			Run:      runListCommand,
+		},
+		{
+			Name:     "cp",
+			Args:     "src dst",
+			Synopsis: "copy file with scp. remote path is query:path. (ex: rnssh cp web:/var/log/app.log .)",
+			Accepts: func(args []string) bool {
+				return len(args) == 2 && (isRemotePath(args[0]) || isRemotePath(args[1]))
+			},
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				addTargetFlags(fs, opt)
+				addSelectorFlags(fs, opt)
+				addSshFlags(fs, opt)
+				fs.BoolVar(&opt.Recursive, "R", false, "copy directories recursively")
+			},
+			Run: runCpCommand,
+		},
+		{
+			Name:     "tunnel",
+			Args:     "-L [local_port:]remote_host:remote_port [user@]query ...",
+			Synopsis: "port forwarding via the chosen host. (ssh -N -L)",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				addTargetFlags(fs, opt)
+				addSelectorFlags(fs, opt)
+				addSshFlags(fs, opt)
+				fs.Var(&opt.LocalForwards, "L", "local port forwarding `[local_port:]remote_host:remote_port`. can be specified multiple times")
+			},
+			Run: runTunnelCommand,
+		},
+		{
+			Name:     "config",
+			Args:     "init|path|show|validate|set key=value ...|migrate [path]|trust [path]|untrust [path]",
+			Actions:  []string{"init", "path", "show", "validate", "set", "migrate", "trust", "untrust"},
+			Synopsis: "manage rnssh config.\n  init: run configuration wizard. answers can be specified by options. (-no-prompt uses default for others)\n  path: show config file path.\n  show: show effective options and where each value came from. (flag, project/config file, env, default)\n  validate: check config file and project config (.rnssh.toml).\n  set: set config values. (to the profile with -profile)\n       map value is key.name=value (ex: ssh_user_by_ami.ubuntu=ubuntu). empty value removes it.\n  migrate: rewrite old config to the current version with backup. (default: rnssh config)\n  trust/untrust: trust project config (.rnssh.toml) without prompt, or forget it.",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				addTargetFlags(fs, opt)
+				addSelectorFlags(fs, opt)
+				addSshFlags(fs, opt)
+				addInitFlags(fs, opt)
+			},
+			Run: runConfigCommand,
+		},
+		{
+			Name:     "cache",
+			Args:     "ls|info|clear|refresh",
+			Actions:  []string{"ls", "info", "clear", "refresh"},
+			Synopsis: "manage ec2 instances cache.\n  ls: list cache files with instance count, age and size.\n  info: show cache of the region.\n  clear: remove cache of the region. (without -r, all regions)\n  refresh: reload instances of the region from AWS.",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				fs.StringVar(&opt.Region, "r", "", "specify region")
+				fs.StringVar(&opt.Region, "region", "", "specify region")
+				fs.StringVar(&opt.Profile, "profile", "", "rnssh config profile name")
+			},
+			Run: runCacheCommand,
+		},
+		{
+			Name:     "sessions",
+			Args:     "ls|play file",
+			Actions:  []string{"ls", "play"},
+			Synopsis: "review recorded ssh sessions. (session_recording config)\n  ls: list recorded sessions with instance, user, profile and time.\n  play: replay the session. file is path or file name in ~/.rnssh/sessions.",
+			Flags: func(fs *flag.FlagSet, opt *CommandOption) {
+				fs.Float64Var(&opt.PlaySpeed, "speed", 1, "play speed. 2 is twice as fast")
+			},
+			Run: runSessionsCommand,
+		},
+		{
+			Name:     "help",
+			Args:     "command",
+			Synopsis: "show usage of the command.",
+			Accepts: func(args []string) bool {
+				return findCommand(args[0]) != nil
+			},
+			Run: runHelpCommand,
+		},
+	}
+}
+
+func findCommand(name string) *Command {
+	for _, c := range commands() {
+		if c.Name == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// isCommandArgs returns true if the args after command name are for the command.
+// action, option, -- or args that the command accepts are needed, so bare "rnssh cache" is query as before.
+func isCommandArgs(c *Command, args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	if strings.HasPrefix(args[0], "-") {
+		return true
+	}
+
+	for _, action := range c.Actions {
+		if args[0] == action {
+			return true
+		}
+	}
+
+	if c.RemoteArgs != "" {
+		for _, arg := range args {
+			if arg == "--" {
+				return true
+			}
+		}
+	}
+
+	return c.Accepts != nil && c.Accepts(args)
+}
+
+func (a *App) runSubcommand(c *Command, args []string) error {
+	// -- of the command without remote args is end of options. (ex: rnssh list -- cache)
+	var remoteArgs []string
+	for i, arg := range args {
+		if arg == "--" && c.RemoteArgs != "" {
+			args, remoteArgs = args[:i], args[i+1:]
+			break
+		}
+	}
+
+	var action []string
+	if len(c.Actions) > 0 && len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[:1], args[1:]
+	}
+
+	opt := &CommandOption{StrictHostKeyCheckingNo: -1}
+	fs := c.newFlagSet(opt, a.Stderr)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if opt.ShowUsage {
+		c.WriteUsage(a.Stdout, fs)
+		return nil
+	}
+
+	if c.RemoteArgs == "" {
+		for _, arg := range fs.Args() {
+			if arg == "--" {
+				return fmt.Errorf("rnssh %s does not accept args after --", c.Name)
+			}
+		}
+	}
+
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+
+	return c.Run(a, opt, append(action, fs.Args()...), remoteArgs)
+}
+
+// WriteUsage writes rnssh usage with commands and options of legacy style.
+func WriteUsage(w io.Writer) error {
+	fmt.Fprint(w, `rnssh - easy ssh login to EC2.
+
+usage:
+  rnssh [options] [user@]query ...   (same as rnssh ssh)
+  rnssh <command> [options] [args]
+  rnssh help <command>
+
+command runs if action, option or -- follows it. otherwise it is query. (ex: rnssh cache, rnssh list -- web)
+
+commands:
+`)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands() {
+		synopsis := strings.SplitN(c.Synopsis, "\n", 2)[0]
+		fmt.Fprintf(tw, "  %s\t%s\n", c.Name, synopsis)
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "\noptions:")
+	writeFlags(w, newFlagSet(&CommandOption{}, io.Discard))
+
+	fmt.Fprint(w, `
+host type can be set ordered fallback list by config (host_type) or RNSSH_HOST_TYPE. (ex: public,private,ssm)
+without -l, user@ and ssh_user config, rnssh detects ssh user from instance tag (SshUser) or AMI name.
+windows instance is connected with RDP. administrator password is decrypted with identity file (-i).
+every config key can be set by RNSSH_<KEY> environment variable. (ex: RNSSH_SSH_USER, RNSSH_PROFILE)
+priority is option > config > env > default. RNSSH_ENV_OVERRIDE=1 makes env prior to config.
+`)
+
+	fmt.Fprintln(w, "\nenvironment variables:")
+	writeWrapped(w, ConfigEnvNames(), "  ", 80)
+
+	return nil
+}
+
+// writeWrapped writes words in lines that are not longer than width.
+func writeWrapped(w io.Writer, words []string, indent string, width int) {
+	line := indent
+	for _, word := range words {
+		if line != indent && len(line)+1+len(word) > width {
+			fmt.Fprintln(w, line)
+			line = indent
+		}
+
+		if line != indent {
+			line += " "
+		}
+		line += word
+	}
+
+	if line != indent {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// writeFlags writes flags except hidden flags. aliases (same value) are shown in one line.
+func writeFlags(w io.Writer, fs *flag.FlagSet) {
+	type flagGroup struct {
+		names []string
+		f     *flag.Flag
+	}
+
+	groups := make([]*flagGroup, 0)
+	byValue := make(map[flag.Value]*flagGroup)
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+
+		if g, ok := byValue[f.Value]; ok {
+			g.names = append(g.names, "-"+f.Name)
+			return
+		}
+
+		g := &flagGroup{names: []string{"-" + f.Name}, f: f}
+		byValue[f.Value] = g
+		groups = append(groups, g)
+	})
+
+	for _, g := range groups {
+		name, usage := flag.UnquoteUsage(g.f)
+		line := "  " + strings.Join(g.names, ", ")
+		if name != "" {
+			line += " " + name
+		}
+
+		fmt.Fprintln(w, line)
+		fmt.Fprintf(w, "      %s", usage)
+		if g.f.DefValue != "" && g.f.DefValue != "false" && g.f.DefValue != "0" && g.f.DefValue != "[]" {
+			fmt.Fprintf(w, " (default %s)", g.f.DefValue)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// stringsFlag is flag that can be specified multiple times.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return fmt.Sprint([]string(*s))
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+func (a *App) loadRnsshOption(opt *CommandOption) (*RnsshOption, *EC2Handler, error) {
+	files, err := a.loadConfigFiles(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rOpt, err := a.rnsshOption(files, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rOpt, a.NewEC2Handler(rOpt.EC2Endpoint, rOpt.CacheTTL), nil
+}
+
+func runSshCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	rOpt, handler, err := a.loadRnsshOption(opt)
+	if err != nil {
+		return err
+	}
+
+	return a.ssh(rOpt, handler, opt, args)
+}
+
+func runExecCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(remoteArgs) == 0 {
+		return fmt.Errorf("command is empty. please specify command after --. (ex: rnssh exec web -- uptime)")
+	}
+
+	rOpt, handler, err := a.loadRnsshOption(opt)
+	if err != nil {
+		return err
+	}
+
+	return a.ssh(rOpt, handler, opt, args, remoteArgs...)
+}
+
+func runListCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	rOpt, handler, err := a.loadRnsshOption(opt)
+	if err != nil {
+		return err
+	}
+
+	choosableList, err := loadChoosableList(rOpt, handler)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range MatchChoices(strings.Join(args, " "), choosableList) {
+		fmt.Fprintln(a.Stdout, c.Choice())
+	}
+
+	return nil
+}
+
+func runCpCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("please specify src and dst. (ex: rnssh cp web:/var/log/app.log .)")
+	}
+
+	remoteIdx := -1
+	for i, arg := range args {
+		if isRemotePath(arg) {
+			if remoteIdx != -1 {
+				return fmt.Errorf("both src and dst are remote. please specify one remote path (query:path)")
+			}
+			remoteIdx = i
+		}
+	}
+	if remoteIdx == -1 {
+		return fmt.Errorf("remote path is not found. please specify src or dst as query:path")
+	}
+
+	query, path := splitRemotePath(args[remoteIdx])
+
+	rOpt, handler, err := a.loadRnsshOption(opt)
+	if err != nil {
+		return err
+	}
+
+	targetHost, sshUser, err := a.chooseHost(rOpt, handler, []string{query})
+	if err != nil {
+		return err
+	}
+
+	if e, ok := targetHost.(*ChoosableEC2); ok && e.IsWindows() {
+		return fmt.Errorf("can not copy file with windows instance %s (%s)", e.InstanceId, e.Name)
+	}
+
+	if err := a.beforeConnect(rOpt, handler, targetHost, opt.ShowCommand); err != nil {
+		return err
+	}
+
+	sshUser, identityFile, sshOptions, err := resolveSshSettings(rOpt, targetHost, sshUser)
+	if err != nil {
+		return err
+	}
+
+	if sshUser == "" {
+		sshUser = rOpt.SshUser
+	}
+
+	scpArgs := genScpOptions(identityFile, rOpt.Port, rOpt.StrictHostKeyCheckingNo, sshOptions, opt.Recursive)
+
+	remote := targetHost.Value() + ":" + path
+	if sshUser != "" {
+		remote = sshUser + "@" + remote
+	}
+
+	paths := []string{args[0], args[1]}
+	paths[remoteIdx] = remote
+	scpArgs = append(scpArgs, paths...)
+
+	return a.runOrShow(opt.ShowCommand, "scp", scpArgs)
+}
+
+// remote path is query:path. local path that includes / before : (ex: ./a:b) is not remote.
+func isRemotePath(p string) bool {
+	idx := strings.Index(p, ":")
+	return idx > 0 && !strings.Contains(p[:idx], "/")
+}
+
+func splitRemotePath(p string) (string, string) {
+	splited := strings.SplitN(p, ":", 2)
+	return splited[0], splited[1]
+}
+
+// scp options are different from ssh. (-P port, -l is bandwidth limit)
+func genScpOptions(identityFile string, port, strictHostKeyCheckingNo int, sshOptions []string, recursive bool) []string {
+	args := make([]string, 0)
+	if recursive {
+		args = append(args, "-r")
+	}
+
+	if identityFile != "" {
+		args = append(args, "-i"+identityFile)
+	}
+
+	if port > 0 {
+		args = append(args, "-P"+fmt.Sprint(port))
+	}
+
+	if strictHostKeyCheckingNo == 1 {
+		args = append(args, "-oStrictHostKeyChecking=no")
+		args = append(args, "-oUserKnownHostsFile=/dev/null")
+	}
+
+	for _, o := range sshOptions {
+		args = append(args, "-o"+o)
+	}
+
+	return args
+}
+
+func runTunnelCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(opt.LocalForwards) == 0 {
+		return fmt.Errorf("please specify port forwarding with -L. (ex: -L 5432:db.internal:5432)")
+	}
+
+	forwardArgs := []string{"-N"}
+	for _, l := range opt.LocalForwards {
+		f, err := parseLocalForward(l)
+		if err != nil {
+			return err
+		}
+		forwardArgs = append(forwardArgs, "-L"+f)
+	}
+
+	rOpt, handler, err := a.loadRnsshOption(opt)
+	if err != nil {
+		return err
+	}
+
+	targetHost, sshUser, err := a.chooseHost(rOpt, handler, args)
+	if err != nil {
+		return err
+	}
+
+	if e, ok := targetHost.(*ChoosableEC2); ok && e.IsWindows() {
+		return fmt.Errorf("can not port forwarding via windows instance %s (%s)", e.InstanceId, e.Name)
+	}
+
+	if err := a.beforeConnect(rOpt, handler, targetHost, opt.ShowCommand); err != nil {
+		return err
+	}
+
+	sshArgs, err := genSshArgsForHost(rOpt, targetHost, sshUser)
+	if err != nil {
+		return err
+	}
+
+	return a.runOrShow(opt.ShowCommand, "ssh", append(forwardArgs, sshArgs...))
+}
+
+// parseLocalForward accepts remote_host:remote_port (same local port) or ssh -L format.
+func parseLocalForward(l string) (string, error) {
+	parts := strings.Split(l, ":")
+	switch len(parts) {
+	case 2:
+		return parts[1] + ":" + l, nil
+	case 3, 4:
+		return l, nil
+	default:
+		return "", fmt.Errorf("invalid -L value: %s. allow [local_port:]remote_host:remote_port", l)
+	}
+}
+
+func runConfigCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("please specify config command. init, path, show, validate, set, migrate, trust or untrust")
+	}
+
+	switch args[0] {
+	case "init":
+		return a.configWizard(opt)
+	case "path":
+		fmt.Fprintln(a.Stdout, a.configPath())
+		return nil
+	case "show":
+		files, err := a.loadConfigFiles(opt)
+		if err != nil {
+			return err
+		}
+
+		profile := profileName(opt)
+		layers, err := configLayers(files, profile)
+		if err != nil {
+			return err
+		}
+
+		rOpt, err := mergeConfig(*opt, layers...)
+		if err != nil {
+			return err
+		}
+
+		WriteConfigShow(a.Stdout, files, profile, rOpt)
+		return nil
+	case "validate":
+		paths := []string{a.configPath()}
+		if p := findProjectConfig(a.WorkDir); p != "" {
+			paths = append(paths, p)
+		}
+
+		count := 0
+		for _, path := range paths {
+			messages, warnings, err := ValidateConfigFile(path)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			for _, w := range warnings {
+				fmt.Fprintf(a.Stdout, "warn: %s\n", w)
+			}
+
+			for _, m := range messages {
+				fmt.Fprintln(a.Stdout, m)
+			}
+
+			if len(messages) == 0 {
+				fmt.Fprintf(a.Stdout, "%s is valid.\n", path)
+			}
+			count += len(messages)
+		}
+
+		if count > 0 {
+			return fmt.Errorf("config has %d error(s)", count)
+		}
+
+		return nil
+	case "trust", "untrust":
+		path := findProjectConfig(a.WorkDir)
+		if len(args) > 1 {
+			var err error
+			path, err = filepath.Abs(args[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		if path == "" {
+			return fmt.Errorf("%s is not found in current dir and parent dirs", PROJECT_CONFIG_NAME)
+		}
+
+		if args[0] == "untrust" {
+			if err := UntrustConfig(a.RnsshDir, path); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(a.Stdout, "untrusted %s\n", path)
+			return nil
+		}
+
+		if _, _, err := loadProjectConfig(path); err != nil {
+			return err
+		}
+
+		if err := TrustConfig(a.RnsshDir, path); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(a.Stdout, "trusted %s\n", path)
+		return nil
+	case "migrate":
+		path := a.configPath()
+		if len(args) > 1 {
+			path = args[1]
+		}
+
+		backup, changes, err := MigrateConfigFile(path, time.Now())
+		if err != nil {
+			return err
+		}
+
+		if backup == "" {
+			fmt.Fprintf(a.Stdout, "%s is already version %d.\n", path, CONFIG_VERSION)
+			return nil
+		}
+
+		fmt.Fprintf(a.Stdout, "migrated %s to version %d. (backup: %s)\n", path, CONFIG_VERSION, backup)
+		for _, c := range changes {
+			fmt.Fprintf(a.Stdout, "  %s\n", c)
+		}
+		return nil
+	case "set":
+		if len(args) < 2 {
+			return fmt.Errorf("please specify key=value. (ex: rnssh config set ssh_user=ec2-user)")
+		}
+
+		// invalid config can be fixed by set.
+		conf, warnings, err := a.readConfig()
+		if err != nil {
+			return err
+		}
+		a.warnConfig(a.configPath(), warnings)
+
+		backup, err := SetConfigFile(a.configPath(), conf, profileName(opt), args[1:], time.Now())
+		if err != nil {
+			return fmt.Errorf("config is not saved: %s", err.Error())
+		}
+
+		if backup != "" {
+			fmt.Fprintf(a.Stdout, "rewrote whole config, so comments and order of keys are not kept. (backup: %s)\n", backup)
+		}
+		fmt.Fprintln(a.Stdout, "saved rnssh config.")
+		return nil
+	default:
+		return fmt.Errorf("unknown config command: %s. allow init, path, show, validate, set, migrate, trust or untrust", args[0])
+	}
+}
+
+func runCacheCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("please specify cache command. ls, info, clear or refresh")
+	}
+
+	switch args[0] {
+	case "ls":
+		infos, err := ListCacheInfo(a.RnsshDir)
+		if err != nil {
+			return err
+		}
+
+		WriteCacheList(a.Stdout, infos)
+		return nil
+	case "info":
+		// cache is not for ssh config.
+		opt.UseEC2 = true
+		rOpt, _, err := a.loadRnsshOption(opt)
+		if err != nil {
+			return err
+		}
+
+		info, err := LoadCacheInfo(rOpt.Region, cacheFilePath(a.RnsshDir, rOpt.Region))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("there is no cache of %s", rOpt.Region)
+			}
+			return err
+		}
+
+		WriteCacheInfo(a.Stdout, info)
+		return nil
+	case "clear":
+		// without -r, clear all regions.
+		removed, err := ClearCache(a.RnsshDir, opt.Region)
+		for _, path := range removed {
+			fmt.Fprintf(a.Stdout, "removed %s\n", path)
+		}
+
+		return err
+	case "refresh":
+		opt.UseEC2 = true
+		rOpt, handler, err := a.loadRnsshOption(opt)
+		if err != nil {
+			return err
+		}
+
+		// no running instance is also cached. (empty list)
+		is, err := handler.RefreshCache(rOpt.Region)
+		if err != nil {
+			return err
+		}
+
+		count := len(ConvertChoosableList(is.Instances, is.ImageNames, HOST_TYPE_PUBLIC_IP, ""))
+		fmt.Fprintf(a.Stdout, "cached %d instances in %s.\n", count, rOpt.Region)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache command: %s. allow ls, info, clear or refresh", args[0])
+	}
+}
+
+func runSessionsCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("please specify sessions command. ls or play")
+	}
+
+	switch args[0] {
+	case "ls":
+		infos, err := ListSessions(a.RnsshDir)
+		if err != nil {
+			return err
+		}
+
+		WriteSessionList(a.Stdout, infos)
+		return nil
+	case "play":
+		if len(args) != 2 {
+			return fmt.Errorf("please specify one session file. see rnssh sessions ls")
+		}
+
+		path, err := findSession(a.RnsshDir, args[1])
+		if err != nil {
+			return err
+		}
+
+		return PlaySession(a.Stdout, path, opt.PlaySpeed, time.Sleep)
+	default:
+		return fmt.Errorf("unknown sessions command: %s. allow ls or play", args[0])
+	}
+}
+
+func runHelpCommand(a *App, opt *CommandOption, args, remoteArgs []string) error {
+	if len(args) == 0 {
+		return WriteUsage(a.Stdout)
+	}
+
+	c := findCommand(args[0])
+	if c == nil {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+
+	c.WriteUsage(a.Stdout, c.newFlagSet(&CommandOption{}, io.Discard))
+	return nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/reiki4040/cstore"
+)
+
+const (
+	COMPLETE_HOSTS      = "hosts"
+	COMPLETE_REGIONS    = "regions"
+	COMPLETE_PROFILES   = "profiles"
+	COMPLETE_HOST_TYPES = "host-types"
+	COMPLETE_SELECTORS  = "selectors"
+	COMPLETE_SHELLS     = "shells"
+
+	// shell completes file path
+	COMPLETE_FILES = "files"
+)
+
+// flag name -> completion candidates kind of the flag value.
+var flagValueCompletions = map[string]string{
+	"r":          COMPLETE_REGIONS,
+	"region":     COMPLETE_REGIONS,
+	"profile":    COMPLETE_PROFILES,
+	"host-type":  COMPLETE_HOST_TYPES,
+	"selector":   COMPLETE_SELECTORS,
+	"completion": COMPLETE_SHELLS,
+
+	"i":             COMPLETE_FILES,
+	"identity-file": COMPLETE_FILES,
+}
+
+// WriteCompletionCandidates writes candidates per line. it does not connect to AWS.
+func WriteCompletionCandidates(w io.Writer, kind string, conf *Config, rnsshDir string) error {
+	var candidates []string
+	switch kind {
+	case COMPLETE_HOSTS:
+		candidates = cachedHostCandidates(rnsshDir)
+	case COMPLETE_REGIONS:
+		for _, r := range AWSRegionList {
+			candidates = append(candidates, r.Value())
+		}
+	case COMPLETE_PROFILES:
+		candidates = conf.ProfileNames()
+	case COMPLETE_HOST_TYPES:
+		candidates = []string{HOST_TYPE_PUBLIC_IP, HOST_TYPE_PRIVATE_IP, HOST_TYPE_NAME_TAG, HOST_TYPE_SSM, "public,private", "public,private,ssm"}
+	case COMPLETE_SELECTORS:
+		candidates = []string{SELECTOR_PECO, SELECTOR_FZF, SELECTOR_SKIM, SELECTOR_BUILTIN}
+	case COMPLETE_SHELLS:
+		candidates = []string{"bash", "zsh", "fish"}
+	default:
+		return fmt.Errorf("unknown completion kind: %s", kind)
+	}
+
+	for _, c := range candidates {
+		fmt.Fprintln(w, c)
+	}
+
+	return nil
+}
+
+// instance names and ids in all region cache files.
+func cachedHostCandidates(rnsshDir string) []string {
+	files, err := cacheFiles(rnsshDir)
+	if err != nil {
+		return nil
+	}
+
+	exists := make(map[string]bool)
+	for _, f := range files {
+		is := Instances{}
+		if err := cstore.LoadFromJsonFile(f, &is); err != nil || is.Version != INSTANCES_CACHE_VERSION {
+			continue
+		}
+
+		for _, c := range ConvertChoosableList(is.Instances, is.ImageNames, HOST_TYPE_SSM, "") {
+			e := c.(*ChoosableEC2)
+			for _, v := range []string{e.Name, e.InstanceId} {
+				if v != "" {
+					exists[v] = true
+				}
+			}
+		}
+	}
+
+	candidates := make([]string, 0, len(exists))
+	for c := range exists {
+		candidates = append(candidates, c)
+	}
+	sort.Strings(candidates)
+
+	return candidates
+}
+
+type completionFlag struct {
+	Name     string
+	Usage    string
+	HasValue bool
+	Complete string
+}
+
+type completionValues struct {
+	Flags []completionFlag
+}
+
+func (v completionValues) CommandNames() string {
+	names := make([]string, 0)
+	for _, c := range commands() {
+		names = append(names, c.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+func (v completionValues) FlagNames() string {
+	names := make([]string, 0, len(v.Flags))
+	for _, f := range v.Flags {
+		names = append(names, "-"+f.Name)
+	}
+
+	return strings.Join(names, " ")
+}
+
+// completion flags are generated from flag definitions.
+func completionFlags() []completionFlag {
+	fs := newFlagSet(&CommandOption{}, io.Discard)
+
+	flags := make([]completionFlag, 0)
+	fs.VisitAll(func(f *flag.Flag) {
+		if hiddenFlags[f.Name] {
+			return
+		}
+
+		hasValue := true
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			hasValue = false
+		}
+
+		flags = append(flags, completionFlag{
+			Name:     f.Name,
+			Usage:    f.Usage,
+			HasValue: hasValue,
+			Complete: flagValueCompletions[f.Name],
+		})
+	})
+
+	return flags
+}
+
+func WriteCompletionScript(w io.Writer, shell string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletionTemplate
+	case "zsh":
+		script = zshCompletionTemplate
+	case "fish":
+		script = fishCompletionTemplate
+	default:
+		return fmt.Errorf("unsupported shell: %s. allow bash, zsh or fish", shell)
+	}
+
+	t, err := template.New(shell).Funcs(template.FuncMap{
+		"quote": func(s string) string { return shellQuote(s) },
+	}).Parse(script)
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, completionValues{Flags: completionFlags()}); err != nil {
+		return err
+	}
+
+	_, err = w.Write(b.Bytes())
+	return err
+}
+
+const bashCompletionTemplate = `# rnssh bash completion. source <(rnssh -completion bash)
+_rnssh() {
+    local cur prev
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    case "$prev" in
+{{- range .Flags}}{{if eq .Complete "files"}}
+        -{{.Name}}|--{{.Name}})
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return
+            ;;
+{{- else if .Complete}}
+        -{{.Name}}|--{{.Name}})
+            COMPREPLY=($(compgen -W "$(rnssh -complete {{.Complete}} 2>/dev/null)" -- "$cur"))
+            return
+            ;;
+{{- else if .HasValue}}
+        -{{.Name}}|--{{.Name}})
+            COMPREPLY=()
+            return
+            ;;
+{{- end}}{{end}}
+    esac
+
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "{{.FlagNames}}" -- "$cur"))
+        return
+    fi
+
+    if [[ $COMP_CWORD -eq 1 ]]; then
+        COMPREPLY=($(compgen -W "{{.CommandNames}}" -- "$cur"))
+    fi
+
+    local IFS=$'\n'
+    COMPREPLY+=($(compgen -W "$(rnssh -complete hosts 2>/dev/null)" -- "$cur"))
+}
+complete -F _rnssh rnssh
+`
+
+const zshCompletionTemplate = `#compdef rnssh
+# rnssh zsh completion. source <(rnssh -completion zsh)
+_rnssh() {
+    local -a flags
+    flags=({{.FlagNames}})
+
+    case "$words[CURRENT-1]" in
+{{- range .Flags}}{{if eq .Complete "files"}}
+        -{{.Name}}|--{{.Name}})
+            _files
+            return
+            ;;
+{{- else if .Complete}}
+        -{{.Name}}|--{{.Name}})
+            compadd -- ${(f)"$(rnssh -complete {{.Complete}} 2>/dev/null)"}
+            return
+            ;;
+{{- else if .HasValue}}
+        -{{.Name}}|--{{.Name}})
+            return
+            ;;
+{{- end}}{{end}}
+    esac
+
+    if [[ "$PREFIX" == -* ]]; then
+        compadd -- $flags
+        return
+    fi
+
+    if (( CURRENT == 2 )); then
+        compadd -- {{.CommandNames}}
+    fi
+
+    compadd -- ${(f)"$(rnssh -complete hosts 2>/dev/null)"}
+}
+compdef _rnssh rnssh
+`
+
+const fishCompletionTemplate = `# rnssh fish completion. rnssh -completion fish | source
+complete -c rnssh -f
+{{- range .Flags}}
+complete -c rnssh -o {{.Name}} -d {{quote .Usage}}
+{{- if eq .Complete "files"}} -r -F{{else if .Complete}} -x -a '(rnssh -complete {{.Complete}} 2>/dev/null)'{{else if .HasValue}} -x{{end}}
+{{- end}}
+complete -c rnssh -n '__fish_use_subcommand' -a '{{.CommandNames}}'
+complete -c rnssh -n 'not string match -q -- "-*" (commandline -ct)' -a '(rnssh -complete hosts 2>/dev/null)'
+`
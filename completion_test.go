@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAppRunComplete(t *testing.T) {
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n\n[[profiles]]\n  profile_name = \"staging\"\n  aws_region = \"us-west-2\"\n"
+	a := newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+
+	// no cache
+	if code := a.Run([]string{"-complete", "hosts"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+	if a.Out.String() != "" {
+		t.Errorf("expected no candidates but %q", a.Out.String())
+	}
+
+	if code := a.Run([]string{"-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	tests := []struct {
+		kind     string
+		expected string
+	}{
+		{COMPLETE_HOSTS, "i-0001\nweb1\n"},
+		{COMPLETE_PROFILES, "staging\n"},
+	}
+
+	for _, tt := range tests {
+		a.Out.Reset()
+		if code := a.Run([]string{"-complete", tt.kind}); code != 0 {
+			t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+		}
+
+		if a.Out.String() != tt.expected {
+			t.Errorf("%s: expected %q but %q", tt.kind, tt.expected, a.Out.String())
+		}
+	}
+
+	if a.EC2.DescribeInstancesCount != 1 {
+		t.Errorf("expected completion does not connect to AWS but DescribeInstances %d times", a.EC2.DescribeInstancesCount)
+	}
+}
+
+func TestWriteCompletionScript(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var b bytes.Buffer
+		if err := WriteCompletionScript(&b, shell); err != nil {
+			t.Fatalf("%s: unexpected error: %v", shell, err)
+		}
+
+		for _, s := range []string{"region", "-complete regions", "-complete hosts"} {
+			if !strings.Contains(b.String(), s) {
+				t.Errorf("%s: expected script contains %q", shell, s)
+			}
+		}
+
+		if strings.Contains(b.String(), "-describe") {
+			t.Errorf("%s: expected script does not contain hidden flag", shell)
+		}
+	}
+
+	if err := WriteCompletionScript(&bytes.Buffer{}, "tcsh"); err == nil {
+		t.Errorf("expected error for unsupported shell but nil")
+	}
+}
@@ -4,252 +4,417 @@ import (
 	"fmt"
 	"os"
 	"os/user"
+	"reflect"
 	"strconv"
 	"strings"
-
-	"github.com/reiki4040/cstore"
-	"github.com/reiki4040/peco"
+	"time"
 )
 
 const (
 	HOST_TYPE_PUBLIC_IP  = "public"
 	HOST_TYPE_PRIVATE_IP = "private"
 	HOST_TYPE_NAME_TAG   = "name"
+	HOST_TYPE_SSM        = "ssm"
+
+	HOST_TYPE_SEPARATOR = ","
+
+	NETWORK_INTERFACE_SUBNET_PREFIX = "subnet-"
+	NETWORK_INTERFACE_TAG_PREFIX    = "tag:"
 )
 
 type Config struct {
+	// config schema version. old config is migrated with `rnssh config migrate`.
+	Version int `toml:"version,omitempty"`
+
 	Default RnsshConfig
+
+	// named profiles. the values of profile override Default. select with -profile option.
+	Profiles []RnsshConfig `toml:"profiles,omitempty"`
+
+	// keys that are defined in the file. zero value of them also overrides. (ex: use_ssh_config = false in profile)
+	defaultKeys map[string]bool
+	profileKeys []map[string]bool
 }
 
 func (c *Config) Validate() error {
-	return c.Default.Validate()
+	if err := c.Default.Validate(); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool)
+	for _, p := range c.Profiles {
+		if p.Name == "" {
+			return fmt.Errorf("profile_name is empty in profiles")
+		}
+
+		if names[p.Name] {
+			return fmt.Errorf("duplicate profile_name: %s", p.Name)
+		}
+		names[p.Name] = true
+
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("profile %s: %s", p.Name, err.Error())
+		}
+	}
+
+	return nil
 }
 
-type RnsshConfig struct {
-	Name                       string `toml:"profile_name,omitempty"`
-	AWSRegion                  string `toml:"aws_region,omitempty"`
-	HostType                   string `toml:"host_type,omitempty"`
-	SshUser                    string `toml:"ssh_user,omitempty"`
-	SshIdentityFile            string `toml:"ssh_identitiy_file,omitempty"`
-	SshPort                    int    `toml:"ssh_port,omitzero"`
-	SshStrictHostKeyCheckingNo int    `toml:"ssh_strict_host_key_checking_no,omitzero"`
+// Profile returns the config of the profile that is layered over Default.
+// empty name is Default.
+func (c *Config) Profile(name string) (*RnsshConfig, error) {
+	if name == "" || name == c.Default.Name {
+		return &c.Default, nil
+	}
 
-	UseSshConfig bool `toml:"use_ssh_config"`
+	for i, p := range c.Profiles {
+		if p.Name == name {
+			merged := c.Default
+			overlayConfig(&merged, &p, c.definedProfileKeys(i))
+			return &merged, nil
+		}
+	}
 
-	//AWSKey                     string `toml:"aws_access_key_id"`
-	//AWSSecret                  string `toml:"aws_secret_access_key"`
+	return nil, fmt.Errorf("profile is not found: %s", name)
 }
 
-func (c *RnsshConfig) Validate() error {
+// DefinedKeys returns keys that are defined for the profile (and Default) in the file.
+func (c *Config) DefinedKeys(name string) map[string]bool {
+	keys := make(map[string]bool)
+	for k := range c.defaultKeys {
+		keys[k] = true
+	}
 
-	if err := HostTypeCheck(c.HostType); err != nil {
-		return err
+	if name == "" || name == c.Default.Name {
+		return keys
 	}
 
-	if err := IdentityFileCheck(c.SshIdentityFile); err != nil {
-		return err
+	for i, p := range c.Profiles {
+		if p.Name == name {
+			for k := range c.definedProfileKeys(i) {
+				keys[k] = true
+			}
+		}
 	}
 
-	if err := StrictHostKeyCheckingNoCheck(c.SshStrictHostKeyCheckingNo); err != nil {
-		return err
+	return keys
+}
+
+func (c *Config) definedProfileKeys(i int) map[string]bool {
+	if i < len(c.profileKeys) {
+		return c.profileKeys[i]
 	}
 
 	return nil
 }
 
-func HostTypeCheck(t string) error {
-	switch t {
-	case HOST_TYPE_PUBLIC_IP:
-		fallthrough
-	case HOST_TYPE_PRIVATE_IP:
-		fallthrough
-	case HOST_TYPE_NAME_TAG:
-		fallthrough
-	case "":
-		return nil
-	default:
-		return fmt.Errorf("invalid HostType value: %s. allow public, private, name or \"\"(default)", t)
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles)+1)
+	if c.Default.Name != "" {
+		names = append(names, c.Default.Name)
+	}
+
+	for _, p := range c.Profiles {
+		names = append(names, p.Name)
 	}
+
+	return names
 }
 
-func StrictHostKeyCheckingNoCheck(v int) error {
-	switch v {
-	case 1:
-		fallthrough
-	case 0:
-		fallthrough
-	case -1:
-		return nil
-	default:
-		return fmt.Errorf("invalid StrictHostKeyCheckingNo value: %d. allow 0(off), 1(on), -1(not specify)", v)
+// overlayConfig overwrites base with non-empty values and defined keys of over.
+func overlayConfig(base, over *RnsshConfig, keys map[string]bool) {
+	bv := reflect.ValueOf(base).Elem()
+	ov := reflect.ValueOf(over).Elem()
+	for i := 0; i < ov.NumField(); i++ {
+		if !ov.Field(i).IsZero() || keys[configKey(ov.Type().Field(i))] {
+			bv.Field(i).Set(ov.Field(i))
+		}
 	}
 }
 
-func IdentityFileCheck(path string) error {
-	if path != "" {
-		// replace ~ -> home dir
-		if i := strings.Index(path, "~"); i == 0 {
-			user, err := user.Current()
-			if err != nil {
-				return fmt.Errorf("can not resolved home dir: %s", err.Error())
+// value sources of option.
+const (
+	SOURCE_DEFAULT = "default"
+	SOURCE_ENV     = "env"
+	SOURCE_CONFIG  = "config"
+	SOURCE_PROJECT = "project"
+	SOURCE_FLAG    = "flag"
+)
+
+// configLayer is config values from one source.
+type configLayer struct {
+	Source string
+	Config *RnsshConfig
+
+	// keys that are set explicitly. zero value of them also overrides lower layers. (ex: RNSSH_USE_SSH_CONFIG=false)
+	Keys map[string]bool
+}
+
+// mergeConfigLayers overlays layers in order (later wins) and returns merged config and config key -> source.
+func mergeConfigLayers(layers ...configLayer) (*RnsshConfig, map[string]string) {
+	merged := &RnsshConfig{}
+	sources := make(map[string]string)
+
+	mv := reflect.ValueOf(merged).Elem()
+	for _, l := range layers {
+		lv := reflect.ValueOf(l.Config).Elem()
+		for i := 0; i < lv.NumField(); i++ {
+			key := configKey(mv.Type().Field(i))
+			if !lv.Field(i).IsZero() || l.Keys[key] {
+				mv.Field(i).Set(lv.Field(i))
+				sources[key] = l.Source
 			}
-			path = user.HomeDir + string(os.PathSeparator) + path[1:]
 		}
+	}
 
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return fmt.Errorf("not exists: %s", path)
+	return merged, sources
+}
+
+// ConfigKeys returns toml keys of RnsshConfig in field order. (without profile_name)
+func ConfigKeys() []string {
+	t := reflect.TypeOf(RnsshConfig{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if k := configKey(t.Field(i)); k != "profile_name" {
+			keys = append(keys, k)
 		}
 	}
 
-	return nil
+	return keys
 }
 
-func DoConfigWizard(cs *cstore.CStore) error {
-
-	chosenResourceType, err := peco.Choose("rnssh ResourceType option", "Please select resource type", "", ResourceTypeList)
-	if err != nil {
-		return fmt.Errorf("ResourceType choose error:%s", err.Error())
+// configValue returns field value of the toml key.
+func configValue(c *RnsshConfig, key string) (reflect.Value, bool) {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if configKey(v.Type().Field(i)) == key {
+			return v.Field(i), true
+		}
 	}
 
-	resourceType := "ec2"
-	// later win
-	for _, c := range chosenResourceType {
-		resourceType = c.Value()
-	}
+	return reflect.Value{}, false
+}
 
-	var c *Config
-	var region, hostType string
-	var useSshConfig bool
-	var strictHostKeyChecking int
-	switch resourceType {
-	case "ec2":
-		region, hostType, err = Ec2ConfigWizard()
-		if err != nil {
-			return err
-		}
+func configKey(f reflect.StructField) string {
+	return strings.SplitN(f.Tag.Get("toml"), ",", 2)[0]
+}
 
-		strictHostKeyChecking, err = StrictHostKeyCheckingWizard()
-		if err != nil {
-			return err
-		}
+type RnsshConfig struct {
+	Name                       string `toml:"profile_name,omitempty"`
+	AWSRegion                  string `toml:"aws_region,omitempty"`
+	HostType                   string `toml:"host_type,omitempty"`
+	SshUser                    string `toml:"ssh_user,omitempty"`
+	SshIdentityFile            string `toml:"ssh_identity_file,omitempty"`
+	SshPort                    int    `toml:"ssh_port,omitzero"`
+	SshStrictHostKeyCheckingNo int    `toml:"ssh_strict_host_key_checking_no,omitzero"`
 
-		useSshConfig = false
+	UseSshConfig bool `toml:"use_ssh_config"`
 
-	case "ssh_config":
-		strictHostKeyChecking, err = StrictHostKeyCheckingWizard()
-		if err != nil {
-			return err
-		}
+	// fuzzy finder. peco(default), fzf, sk or builtin
+	Selector string `toml:"selector,omitempty"`
 
-		chosenContinue, err := peco.Choose("next setting", "next, continue to AWS settings?", "", ContinueList)
-		if err != nil {
-			return err
-		}
+	// additional options for fzf / sk. ex: ["--bind", "ctrl-y:accept"]
+	SelectorOptions []string `toml:"selector_options,omitempty"`
 
-		chosen := ""
-		for _, c := range chosenContinue {
-			chosen = c.Value()
-		}
+	// EC2 API endpoint for local EC2 compatible stand-in. ex: http://localhost:4566
+	EC2Endpoint string `toml:"ec2_endpoint,omitempty"`
 
-		if chosen == "yes" {
-			region, hostType, err = Ec2ConfigWizard()
-			if err != nil {
-				return err
-			}
-		}
+	// choose network interface that has ssh address.
+	// device index(ex: "1"), subnet id(ex: "subnet-xxxx") or instance tag(ex: "tag:SshInterface")
+	NetworkInterface string `toml:"network_interface,omitempty"`
+
+	// identity file path template by instance key pair. ex: ~/.ssh/{{.KeyName}}.pem
+	SshIdentityFileTemplate string `toml:"ssh_identity_file_template,omitempty"`
+
+	// instance key pair name -> identity file path. prior to template.
+	SshIdentityFileByKeyName map[string]string `toml:"ssh_identity_file_by_key_name,omitempty"`
+
+	// RDP client command for windows instance. ex: open {{.RdpFile}}
+	RdpCommand string `toml:"rdp_command,omitempty"`
+
+	// instance tag key that has ssh login user. default is SshUser.
+	SshUserTag string `toml:"ssh_user_tag,omitempty"`
+
+	// AMI name (or platform details) substring -> ssh login user. override built-in table.
+	SshUserByAMI map[string]string `toml:"ssh_user_by_ami,omitempty"`
+
+	// instances cache is reloaded when it is older than this. ex: 30m, 12h. ""(default) is not expired.
+	CacheTTL string `toml:"cache_ttl,omitempty"`
+
+	// save host keys of EC2 instances to ~/.rnssh/known_hosts by instance id instead of IP address.
+	InstanceKnownHosts bool `toml:"instance_known_hosts,omitempty"`
+
+	// verify host key of EC2 instance with console output before first connect. (implies instance_known_hosts)
+	VerifyHostKey bool `toml:"verify_host_key,omitempty"`
 
-		useSshConfig = true
+	// short-lived ssh user certificate (~/.rnssh/certs/<profile>-cert.pub) is renewed with command or HTTP signer when it is expired.
+	// ex: my-signer -pub {{.PublicKeyFile}} -out {{.CertFile}}
+	SshCertSignCommand string `toml:"ssh_cert_sign_command,omitempty"`
+	SshCertSignerURL   string `toml:"ssh_cert_signer_url,omitempty"`
+
+	// public key that is signed. default is ssh_identity_file + ".pub"
+	SshCertPublicKey string `toml:"ssh_cert_public_key,omitempty"`
+
+	// add identity file to ssh agent if it is not loaded, and ssh uses the key in agent instead of -i.
+	SshAgent bool `toml:"ssh_agent,omitempty"`
+
+	// lifetime of the key that is added to agent. (ssh-add -t) ex: 8h
+	SshAgentLifetime string `toml:"ssh_agent_lifetime,omitempty"`
+
+	// agent socket instead of SSH_AUTH_SOCK (ssh IdentityAgent). ex: separate agent for production profile.
+	SshAgentSocket string `toml:"ssh_agent_socket,omitempty"`
+
+	// record ssh sessions to ~/.rnssh/sessions (asciicast v2) for audit.
+	SessionRecording bool `toml:"session_recording,omitempty"`
+
+	//AWSKey                     string `toml:"aws_access_key_id"`
+	//AWSSecret                  string `toml:"aws_secret_access_key"`
+}
+
+func (c *RnsshConfig) Validate() error {
+	for _, e := range c.ValidateAll() {
+		return e.Err
 	}
 
-	c = &Config{
-		Default: RnsshConfig{
-			AWSRegion:                  region,
-			HostType:                   hostType,
-			UseSshConfig:               useSshConfig,
-			SshStrictHostKeyCheckingNo: strictHostKeyChecking,
-		},
+	return nil
+}
+
+// ConfigError is validation error of the config key.
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+// ValidateAll returns all invalid values with config key.
+func (c *RnsshConfig) ValidateAll() []ConfigError {
+	checks := []ConfigError{
+		{"profile_name", ProfileNameCheck(c.Name)},
+		{"host_type", HostTypeCheck(c.HostType)},
+		{"ssh_identity_file", IdentityFileCheck(c.SshIdentityFile)},
+		{"ssh_identity_file_template", IdentityFileTemplateCheck(c.SshIdentityFileTemplate)},
+		{"ssh_strict_host_key_checking_no", StrictHostKeyCheckingNoCheck(c.SshStrictHostKeyCheckingNo)},
+		{"rdp_command", RdpCommandCheck(c.RdpCommand)},
+		{"selector", SelectorCheck(c.Selector)},
+		{"network_interface", NetworkInterfaceCheck(c.NetworkInterface)},
+		{"cache_ttl", CacheTTLCheck(c.CacheTTL)},
+		{"ssh_cert_sign_command", CertSignCommandCheck(c.SshCertSignCommand)},
+		{"ssh_cert_signer_url", CertSignerURLCheck(c.SshCertSignerURL)},
+		{"ssh_agent_lifetime", SshAgentLifetimeCheck(c.SshAgentLifetime)},
 	}
 
-	if err := cs.Save(c); err != nil {
-		return err
+	errs := make([]ConfigError, 0)
+	for _, e := range checks {
+		if e.Err != nil {
+			errs = append(errs, e)
+		}
+	}
+
+	return errs
+}
+
+func HostTypeCheck(t string) error {
+	for _, ht := range ParseHostTypes(t) {
+		switch ht {
+		case HOST_TYPE_PUBLIC_IP:
+		case HOST_TYPE_PRIVATE_IP:
+		case HOST_TYPE_NAME_TAG:
+		case HOST_TYPE_SSM:
+		default:
+			return fmt.Errorf("invalid HostType value: %s. allow public, private, name, ssm, comma separated list of them (ex: public,private) or \"\"(default)", t)
+		}
 	}
 
 	return nil
 }
 
-func Ec2ConfigWizard() (string, string, error) {
-	chosenRegion, err := peco.Choose("AWS region", "Please select default AWS region", "", AWSRegionList)
-	if err != nil {
-		return "", "", fmt.Errorf("region choose error:%s", err.Error())
+// ParseHostTypes splits host type setting to ordered fallback list.
+// ex: "public,private,ssm" -> [public private ssm]
+func ParseHostTypes(t string) []string {
+	hostTypes := make([]string, 0)
+	for _, ht := range strings.Split(t, HOST_TYPE_SEPARATOR) {
+		ht = strings.TrimSpace(ht)
+		if ht != "" {
+			hostTypes = append(hostTypes, ht)
+		}
 	}
 
-	region := ""
-	for _, c := range chosenRegion {
-		region = c.Value()
+	return hostTypes
+}
+
+func NetworkInterfaceCheck(n string) error {
+	if n == "" || strings.HasPrefix(n, NETWORK_INTERFACE_SUBNET_PREFIX) {
+		return nil
 	}
 
-	chosenHostType, err := peco.Choose("rnssh host type", "Please select default host type", "", HostTypeList)
-	if err != nil {
-		return "", "", fmt.Errorf("host type choose error:%s", err.Error())
+	if strings.HasPrefix(n, NETWORK_INTERFACE_TAG_PREFIX) && len(n) > len(NETWORK_INTERFACE_TAG_PREFIX) {
+		return nil
 	}
 
-	hostType := ""
-	for _, c := range chosenHostType {
-		hostType = c.Value()
+	if i, err := strconv.Atoi(n); err == nil && i >= 0 {
+		return nil
 	}
 
-	return region, hostType, nil
+	return fmt.Errorf("invalid NetworkInterface value: %s. allow device index(ex: 1), subnet id(ex: subnet-xxxx), instance tag(ex: tag:SshInterface) or \"\"(default)", n)
 }
 
-func StrictHostKeyCheckingWizard() (int, error) {
-	chosenStrict, err := peco.Choose("rnssh StrictHostKeyChecking option", "Please select about StrictHostKeyChecking (recommend to Not specify)", "", StrictHostKeyCheckingList)
-	if err != nil {
-		return -1, fmt.Errorf("StrictHostKeyChecking choose error:%s", err.Error())
+func CacheTTLCheck(ttl string) error {
+	if ttl == "" {
+		return nil
 	}
 
-	var strict int
-	for _, c := range chosenStrict {
-		strict, err = strconv.Atoi(c.Value())
-		if err != nil {
-			// error then disabled
-			strict = 0
-		}
+	if d, err := time.ParseDuration(ttl); err != nil || d < 0 {
+		return fmt.Errorf("invalid CacheTTL value: %s. allow duration(ex: 30m, 12h) or \"\"(default)", ttl)
 	}
 
-	return strict, nil
+	return nil
 }
 
-var (
-	AWSRegionList = []peco.Choosable{
-		&peco.Choice{C: "ap-northeast-1 (Tokyo)", V: "ap-northeast-1"},
-		&peco.Choice{C: "ap-southeast-1 (Singapore)", V: "ap-southeast-1"},
-		&peco.Choice{C: "ap-southeast-2 (Sydney)", V: "ap-southeast-2"},
-		&peco.Choice{C: "eu-central-1 (Frankfurt)", V: "eu-central-1"},
-		&peco.Choice{C: "eu-west-1 (Ireland)", V: "eu-west-1"},
-		&peco.Choice{C: "sa-east-1 (Sao Paulo)", V: "sa-east-1"},
-		&peco.Choice{C: "us-east-1 (N. Virginia)", V: "us-east-1"},
-		&peco.Choice{C: "us-west-1 (N. California)", V: "us-west-1"},
-		&peco.Choice{C: "us-west-2 (Oregon)", V: "us-west-2"},
+// ProfileNameCheck rejects the name that can not be file name. (profile name is used for certificate file name)
+func ProfileNameCheck(name string) error {
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid ProfileName value: %s. can not contain /, \\ or ..", name)
 	}
 
-	HostTypeList = []peco.Choosable{
-		&peco.Choice{C: "PublicIP (rnssh default)", V: "public"},
-		&peco.Choice{C: "PrivateIP (for VPN or bastion)", V: "private"},
-		&peco.Choice{C: "Name Tag (need ssh config settings)", V: "name"},
-	}
+	return nil
+}
 
-	StrictHostKeyCheckingList = []peco.Choosable{
-		&peco.Choice{C: "Not specify (rnssh Default)", V: "0"},
-		&peco.Choice{C: "StrictHostKeyChecking=NO (if you don't know this ssh option, then deprecated)", V: "1"},
+func StrictHostKeyCheckingNoCheck(v int) error {
+	switch v {
+	case 1:
+		fallthrough
+	case 0:
+		fallthrough
+	case -1:
+		return nil
+	default:
+		return fmt.Errorf("invalid StrictHostKeyCheckingNo value: %d. allow 0(off), 1(on), -1(not specify)", v)
 	}
+}
 
-	ResourceTypeList = []peco.Choosable{
-		&peco.Choice{C: "AWS EC2 (rnssh Default)", V: "ec2"},
-		&peco.Choice{C: "ssh config (load from ~/.ssh/config)", V: "ssh_config"},
+func IdentityFileCheck(path string) error {
+	if path != "" {
+		path, err := expandHomeDir(path)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("not exists: %s", path)
+		}
 	}
 
-	ContinueList = []peco.Choosable{
-		&peco.Choice{C: "No.", V: "no"},
-		&peco.Choice{C: "Yes, continue to AWS settings", V: "yes"},
+	return nil
+}
+
+// replace ~ -> home dir
+func expandHomeDir(path string) (string, error) {
+	if i := strings.Index(path, "~"); i == 0 {
+		user, err := user.Current()
+		if err != nil {
+			return "", fmt.Errorf("can not resolved home dir: %s", err.Error())
+		}
+		path = user.HomeDir + string(os.PathSeparator) + path[1:]
 	}
-)
+
+	return path, nil
+}
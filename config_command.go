@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// WriteConfigShow writes effective options with the source of each value.
+func WriteConfigShow(w io.Writer, files []*configFile, profile string, rOpt *RnsshOption) {
+	if profile == "" {
+		profile = "(Default)"
+	}
+
+	for _, f := range files {
+		fmt.Fprintf(w, "%s: %s\n", f.Source, f.Path)
+	}
+	fmt.Fprintf(w, "profile: %s\n\n", profile)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, key := range ConfigKeys() {
+		v, _ := configValue(rOpt.Merged, key)
+		source := rOpt.Sources[key]
+		if source == "" {
+			source = "-"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, formatConfigValue(v), source)
+	}
+	tw.Flush()
+}
+
+func formatConfigValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Slice:
+		values := make([]string, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			values = append(values, fmt.Sprint(v.Index(i).Interface()))
+		}
+		return strings.Join(values, " ")
+	case reflect.Map:
+		values := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			values = append(values, fmt.Sprintf("%v=%v", k.Interface(), v.MapIndex(k).Interface()))
+		}
+		sort.Strings(values)
+		return strings.Join(values, ", ")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// ValidateConfigFile checks syntax, values and unknown keys of the config file.
+// it returns error messages and warnings (old keys) with line number. (path:line: message)
+func ValidateConfigFile(path string) ([]string, []string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf, changes, unknown, err := decodeConfig(content)
+	if err != nil {
+		var pErr toml.ParseError
+		if errors.As(err, &pErr) {
+			return []string{fmt.Sprintf("%s:%d: %s", path, pErr.Position.Line, pErr.Message)}, nil, nil
+		}
+		return []string{fmt.Sprintf("%s: %s", path, err.Error())}, nil, nil
+	}
+
+	warnings := make([]string, 0, len(changes))
+	for _, c := range changes {
+		warnings = append(warnings, fmt.Sprintf("%s: old config key: %s. run `rnssh config migrate` to update", path, c))
+	}
+
+	messages := make([]string, 0)
+	for _, k := range unknown {
+		messages = append(messages, fmt.Sprintf("%s:%d: unknown config key: %s", path, unknownKeyLine(content, len(conf.Profiles), k), k))
+	}
+
+	for _, e := range conf.Default.ValidateAll() {
+		line := findConfigKeyLine(content, -1, e.Key)
+		messages = append(messages, fmt.Sprintf("%s:%d: Default.%s: %s", path, line, e.Key, e.Err.Error()))
+	}
+
+	names := make(map[string]bool)
+	for i, p := range conf.Profiles {
+		table := fmt.Sprintf("profiles[%d]", i)
+		if p.Name != "" {
+			table = fmt.Sprintf("profiles[%d](%s)", i, p.Name)
+		}
+
+		if p.Name == "" {
+			line := findConfigKeyLine(content, i, "")
+			messages = append(messages, fmt.Sprintf("%s:%d: %s: profile_name is empty", path, line, table))
+		} else if names[p.Name] {
+			line := findConfigKeyLine(content, i, "profile_name")
+			messages = append(messages, fmt.Sprintf("%s:%d: %s: duplicate profile_name: %s", path, line, table, p.Name))
+		}
+		names[p.Name] = true
+
+		for _, e := range p.ValidateAll() {
+			line := findConfigKeyLine(content, i, e.Key)
+			messages = append(messages, fmt.Sprintf("%s:%d: %s.%s: %s", path, line, table, e.Key, e.Err.Error()))
+		}
+	}
+
+	return messages, warnings, nil
+}
+
+// unknownKeyLine returns line number of unknown key. (ex: Default.foo, profiles.foo) 0 is not found.
+func unknownKeyLine(content []byte, profiles int, key string) int {
+	kv := strings.SplitN(key, ".", 2)
+	if len(kv) != 2 {
+		return 0
+	}
+
+	switch kv[0] {
+	case "Default":
+		return findConfigKeyLine(content, -1, kv[1])
+	case "profiles":
+		for i := 0; i < profiles; i++ {
+			if line := findConfigKeyLine(content, i, kv[1]); line > 0 {
+				return line
+			}
+		}
+	}
+
+	return 0
+}
+
+var (
+	tomlTableRegexp      = regexp.MustCompile(`^\s*\[\s*([^\[\]]+?)\s*\]`)
+	tomlArrayTableRegexp = regexp.MustCompile(`^\s*\[\[\s*([^\[\]]+?)\s*\]\]`)
+)
+
+// findConfigKeyLine returns line number of the key in [Default] (profile -1) or the N-th [[profiles]].
+// old key name is also found. empty key returns the table line. 0 is not found.
+func findConfigKeyLine(content []byte, profile int, key string) int {
+	if line := findTomlKeyLine(content, profile, key); line > 0 || oldConfigKeys[key] == "" {
+		return line
+	}
+
+	return findTomlKeyLine(content, profile, oldConfigKeys[key])
+}
+
+func findTomlKeyLine(content []byte, profile int, key string) int {
+	keyRegexp := regexp.MustCompile(`^\s*"?` + regexp.QuoteMeta(key) + `"?\s*=`)
+
+	table := ""
+	profileIndex := -1
+	tableLine := 0
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if m := tomlArrayTableRegexp.FindStringSubmatch(line); m != nil {
+			table = m[1]
+			if table == "profiles" {
+				profileIndex++
+			}
+		} else if m := tomlTableRegexp.FindStringSubmatch(line); m != nil {
+			table = m[1]
+		} else {
+			if key != "" && inConfigTable(table, profileIndex, profile) && keyRegexp.MatchString(line) {
+				return n
+			}
+			continue
+		}
+
+		if inConfigTable(table, profileIndex, profile) {
+			tableLine = n
+		}
+	}
+
+	if key == "" {
+		return tableLine
+	}
+
+	return 0
+}
+
+func inConfigTable(table string, profileIndex, profile int) bool {
+	if profile < 0 {
+		return table == "Default"
+	}
+
+	return table == "profiles" && profileIndex == profile
+}
+
+// SetConfigValues sets key=value to the config of the profile. (empty is Default)
+// map value is set with key.name=value (ex: ssh_user_by_ami.ubuntu=ubuntu). empty value removes it.
+func SetConfigValues(conf *Config, profile string, assignments []string) error {
+	target := &conf.Default
+	if profile != "" && profile != conf.Default.Name {
+		target = nil
+		for i := range conf.Profiles {
+			if conf.Profiles[i].Name == profile {
+				target = &conf.Profiles[i]
+			}
+		}
+
+		if target == nil {
+			conf.Profiles = append(conf.Profiles, RnsshConfig{Name: profile})
+			target = &conf.Profiles[len(conf.Profiles)-1]
+		}
+	}
+
+	keys := make(map[string]bool, len(assignments))
+	for _, a := range assignments {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid format: %s. please specify key=value", a)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		if err := setConfigValue(target, key, kv[1]); err != nil {
+			return err
+		}
+		keys[strings.SplitN(key, ".", 2)[0]] = true
+	}
+
+	// validate only changed keys. other existing errors are reported by config validate.
+	for _, e := range target.ValidateAll() {
+		if keys[e.Key] {
+			return fmt.Errorf("invalid %s: %s", e.Key, e.Err.Error())
+		}
+	}
+
+	return nil
+}
+
+func setConfigValue(c *RnsshConfig, key, value string) error {
+	name, mapKey := key, ""
+	if i := strings.Index(key, "."); i > 0 {
+		name, mapKey = key[:i], key[i+1:]
+	}
+
+	if name == "profile_name" {
+		return fmt.Errorf("can not set profile_name. please specify profile with -profile")
+	}
+
+	v, ok := configValue(c, name)
+	if !ok {
+		return fmt.Errorf("unknown config key: %s. allow %s", name, strings.Join(ConfigKeys(), ", "))
+	}
+
+	if mapKey != "" && v.Kind() != reflect.Map {
+		return fmt.Errorf("%s is not map. please specify %s=value", name, name)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int:
+		i := 0
+		if value != "" {
+			var err error
+			i, err = strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value: %s. please specify number", name, value)
+			}
+		}
+		v.SetInt(int64(i))
+	case reflect.Bool:
+		b := false
+		if value != "" {
+			var err error
+			b, err = strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value: %s. please specify true or false", name, value)
+			}
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		// space separated list
+		v.Set(reflect.ValueOf(strings.Fields(value)))
+		if v.Len() == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	case reflect.Map:
+		if mapKey == "" {
+			return fmt.Errorf("%s is map. please specify %s.key=value", name, name)
+		}
+
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		if value == "" {
+			v.SetMapIndex(reflect.ValueOf(mapKey), reflect.Value{})
+		} else {
+			v.SetMapIndex(reflect.ValueOf(mapKey), reflect.ValueOf(value))
+		}
+
+		if v.Len() == 0 {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+
+	return nil
+}
+
+// SetConfigFile sets key=value to the profile in the config file. (see SetConfigValues)
+// only the lines of the keys are changed, so comments and order of other keys are kept.
+// if the lines can not be changed (ex: map in sub table, multi-line array), whole config is rewritten
+// and the original file is copied to backup. it returns the backup path. (empty is not rewritten)
+func SetConfigFile(path string, conf *Config, profile string, assignments []string, now time.Time) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// index of the profile in the file before set. -1 is Default, len(profiles) is new profile.
+	target := -1
+	if profile != "" && profile != conf.Default.Name {
+		target = len(conf.Profiles)
+		for i, p := range conf.Profiles {
+			if p.Name == profile {
+				target = i
+			}
+		}
+	}
+
+	if err := SetConfigValues(conf, profile, assignments); err != nil {
+		return "", err
+	}
+
+	c := &conf.Default
+	if target >= 0 {
+		c = &conf.Profiles[target]
+	}
+
+	lines := make(map[string]string)
+	for _, a := range assignments {
+		key := strings.TrimSpace(strings.SplitN(strings.SplitN(a, "=", 2)[0], ".", 2)[0])
+		v, _ := configValue(c, key)
+		line, err := configLine(key, v)
+		if err != nil {
+			return "", err
+		}
+		lines[key] = line
+	}
+
+	edited := editConfigLines(content, target, profile, lines)
+
+	// check edited content is the same as the config. otherwise rewrite whole config.
+	if e, _, err := DecodeConfig(edited); err == nil && reflect.DeepEqual(e.Default, conf.Default) && reflect.DeepEqual(e.Profiles, conf.Profiles) {
+		return "", writeFileAtomic(path, func(w io.Writer) error {
+			_, err := w.Write(edited)
+			return err
+		})
+	}
+
+	backup := ""
+	if len(content) > 0 {
+		backup = fmt.Sprintf("%s.bak.%s", path, now.Format("20060102150405"))
+		if err := writeFileAtomic(backup, func(w io.Writer) error {
+			_, err := w.Write(content)
+			return err
+		}); err != nil {
+			return "", fmt.Errorf("can not backup config: %s", err.Error())
+		}
+	}
+
+	return backup, SaveConfig(path, conf)
+}
+
+// configLine returns toml line of the key. empty is removed. (except bool and number, they override Default)
+// map is inline table.
+func configLine(key string, v reflect.Value) (string, error) {
+	if v.IsZero() && v.Kind() != reflect.Bool && v.Kind() != reflect.Int {
+		return "", nil
+	}
+
+	if v.Kind() != reflect.Map {
+		return encodeTomlLine(key, v.Interface())
+	}
+
+	names := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		names = append(names, k.String())
+	}
+	sort.Strings(names)
+
+	items := make([]string, 0, len(names))
+	for _, n := range names {
+		item, err := encodeTomlLine(n, v.MapIndex(reflect.ValueOf(n)).Interface())
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+	}
+
+	return key + " = {" + strings.Join(items, ", ") + "}", nil
+}
+
+// encodeTomlLine returns "key = value" with quoted key and value if needed.
+func encodeTomlLine(key string, value interface{}) (string, error) {
+	var b bytes.Buffer
+	if err := toml.NewEncoder(&b).Encode(map[string]interface{}{key: value}); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+var (
+	tomlKeyLine = regexp.MustCompile(`^\s*([A-Za-z0-9_-]+)\s*=`)
+	tomlIndent  = regexp.MustCompile(`^\s*`)
+)
+
+// editConfigLines replaces key lines of the target (-1 is Default, index of [[profiles]]) with lines.
+// empty line removes the key. keys that are not found are added after last key of the target.
+// new profile (target is not found) is added to the end.
+func editConfigLines(content []byte, target int, profile string, lines map[string]string) []byte {
+	src := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		src = nil
+	}
+
+	// section of each line. -1 is Default, -2 is others.
+	sections := make([]int, len(src))
+	section, profiles := -2, 0
+	for i, l := range src {
+		switch header := strings.ReplaceAll(strings.TrimSpace(strings.SplitN(l, "#", 2)[0]), " ", ""); {
+		case header == "[Default]":
+			section = -1
+		case header == "[[profiles]]":
+			section = profiles
+			profiles++
+		case strings.HasPrefix(header, "["):
+			section = -2
+		}
+		sections[i] = section
+	}
+
+	out := make([]string, 0, len(src)+len(lines)+3)
+	done := make(map[string]bool)
+	last, indent := -1, "  "
+	for i, l := range src {
+		if sections[i] == target {
+			// header of the target
+			if i == 0 || sections[i-1] != target {
+				last = len(out)
+			}
+
+			if m := tomlKeyLine.FindStringSubmatch(l); m != nil {
+				indent = tomlIndent.FindString(l)
+				if line, ok := lines[m[1]]; ok {
+					done[m[1]] = true
+					if line == "" {
+						continue
+					}
+					l = indent + line
+				}
+				last = len(out)
+			}
+		}
+		out = append(out, l)
+	}
+
+	added := make([]string, 0, len(lines))
+	for _, key := range sortedKeys(lines) {
+		if !done[key] && lines[key] != "" {
+			added = append(added, indent+lines[key])
+		}
+	}
+
+	if last == -1 {
+		header := []string{"", "[Default]"}
+		if target >= 0 {
+			name, _ := encodeTomlLine("profile_name", profile)
+			header = []string{"", "[[profiles]]", indent + name}
+		}
+		if len(out) == 0 {
+			header = header[1:]
+		}
+		out = append(append(out, header...), added...)
+	} else {
+		out = append(out[:last+1], append(added, out[last+1:]...)...)
+	}
+
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// SaveConfig writes config to temp file and renames it. it is saved as the current version.
+func SaveConfig(path string, conf *Config) error {
+	conf.Version = CONFIG_VERSION
+	return writeFileAtomic(path, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(conf)
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func TestAppRunConfigShow(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+  ssh_user = "deploy"
+`
+	a := newTestApp(t, "", config)
+	t.Setenv(ENV_RNSSH_HOST_TYPE, "private")
+
+	if code := a.Run([]string{"config", "show", "-port", "2222"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	source := SOURCE_CONFIG + ":" + filepath.Join(a.RnsshDir, "config")
+	expected := map[string][]string{
+		"aws_region": {"us-west-2", source},
+		"ssh_user":   {"deploy", source},
+		"host_type":  {"private", SOURCE_ENV + ":" + ENV_RNSSH_HOST_TYPE},
+		"ssh_port":   {"2222", SOURCE_FLAG},
+		"selector":   {"peco", SOURCE_DEFAULT},
+	}
+	for key, e := range expected {
+		r := regexp.MustCompile(`(?m)^` + key + `\s+` + e[0] + `\s+` + regexp.QuoteMeta(e[1]) + `$`)
+		if !r.MatchString(a.Out.String()) {
+			t.Errorf("expected %s is %s from %s but %q", key, e[0], e[1], a.Out.String())
+		}
+	}
+}
+
+func TestAppRunConfigValidate(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+  host_type = "public"
+
+[[profiles]]
+  profile_name = "staging"
+  host_type = "unknown"
+  selector = "fzf"
+`
+	a := newTestApp(t, "", config)
+
+	if code := a.Run([]string{"config", "validate"}); code == 0 {
+		t.Fatalf("expected error but exit code 0: %s", a.Out.String())
+	}
+
+	expected := filepath.Join(a.RnsshDir, "config") + ":7: profiles[0](staging).host_type:"
+	if !strings.Contains(a.Out.String(), expected) {
+		t.Errorf("expected %q but %q", expected, a.Out.String())
+	}
+
+	a = newTestApp(t, "", "[Default]\n  ssh_port = \"x\"\n")
+	if code := a.Run([]string{"config", "validate"}); code == 0 {
+		t.Fatalf("expected error but exit code 0: %s", a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "line 2") {
+		t.Errorf("expected type error with line number but %q", a.Out.String())
+	}
+}
+
+func TestAppRunConfigSet(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+
+[[profiles]]
+  profile_name = "broken"
+  host_type = "unknown"
+`
+	a := newTestApp(t, "", config)
+
+	runs := [][]string{
+		{"config", "set", "ssh_user=ec2-user", "ssh_port=2222"},
+		{"config", "set", "ssh_user_by_ami.ubuntu=ubuntu"},
+		{"config", "set", "-profile", "staging", "host_type=private", "selector_options=--height 40%"},
+	}
+	for _, args := range runs {
+		if code := a.Run(args); code != 0 {
+			t.Fatalf("%v: expected exit code 0 but %d: %s", args, code, a.Out.String())
+		}
+	}
+
+	conf := Config{}
+	content, err := os.ReadFile(filepath.Join(a.RnsshDir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toml.Decode(string(content), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Default.AWSRegion != "us-west-2" || conf.Default.SshUser != "ec2-user" || conf.Default.SshPort != 2222 {
+		t.Errorf("unexpected Default: %+v", conf.Default)
+	}
+
+	if conf.Default.SshUserByAMI["ubuntu"] != "ubuntu" {
+		t.Errorf("expected ssh_user_by_ami.ubuntu is set but %v", conf.Default.SshUserByAMI)
+	}
+
+	p, err := conf.Profile("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HostType != "private" || strings.Join(p.SelectorOptions, " ") != "--height 40%" {
+		t.Errorf("unexpected staging profile: %+v", p)
+	}
+
+	for _, args := range [][]string{
+		{"config", "set", "host_type=unknown"},
+		{"config", "set", "ssh_port=x"},
+		{"config", "set", "no_such_key=x"},
+		{"config", "set", "ssh_user"},
+	} {
+		before, _ := os.ReadFile(filepath.Join(a.RnsshDir, "config"))
+		if code := a.Run(args); code == 0 {
+			t.Errorf("%v: expected error but exit code 0", args)
+		}
+
+		after, _ := os.ReadFile(filepath.Join(a.RnsshDir, "config"))
+		if string(before) != string(after) {
+			t.Errorf("%v: expected config is not changed", args)
+		}
+	}
+}
+
+func TestAppRunConfigSetKeepComments(t *testing.T) {
+	config := `# rnssh config
+version = 2
+
+[Default]
+  # default region
+  aws_region = "us-west-2"
+  ssh_user = "deploy" # login user
+  use_ssh_config = true
+
+# production
+[[profiles]]
+  profile_name = "prod"
+  # bastion only
+  host_type = "private"
+
+[[profiles]]
+  profile_name = "stg"
+`
+	a := newTestApp(t, "", config)
+
+	runs := [][]string{
+		{"config", "set", "ssh_user=ec2-user", "ssh_port=2222"},
+		{"config", "set", "-profile", "prod", "use_ssh_config=false", "ssh_user_by_ami.ubuntu=ubuntu"},
+		{"config", "set", "-profile", "dev", "aws_region=eu-west-1"},
+	}
+	for _, args := range runs {
+		if code := a.Run(args); code != 0 {
+			t.Fatalf("%v: expected exit code 0 but %d: %s", args, code, a.Out.String())
+		}
+	}
+
+	expected := `# rnssh config
+version = 2
+
+[Default]
+  # default region
+  aws_region = "us-west-2"
+  ssh_user = "ec2-user"
+  use_ssh_config = true
+  ssh_port = 2222
+
+# production
+[[profiles]]
+  profile_name = "prod"
+  # bastion only
+  host_type = "private"
+  ssh_user_by_ami = {ubuntu = "ubuntu"}
+  use_ssh_config = false
+
+[[profiles]]
+  profile_name = "stg"
+
+[[profiles]]
+  profile_name = "dev"
+  aws_region = "eu-west-1"
+`
+	content, err := os.ReadFile(filepath.Join(a.RnsshDir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != expected {
+		t.Errorf("expected config\n%s\nbut\n%s", expected, content)
+	}
+
+	if strings.Contains(a.Out.String(), "backup") {
+		t.Errorf("expected no backup but %q", a.Out.String())
+	}
+
+	// map in sub table can not be changed by line, so whole config is rewritten with backup.
+	a.Out.Reset()
+	subTable := "[Default]\n  # comment\n\n[Default.ssh_user_by_ami]\n  ubuntu = \"ubuntu\"\n"
+	if err := os.WriteFile(filepath.Join(a.RnsshDir, "config"), []byte(subTable), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := a.Run([]string{"config", "set", "ssh_user_by_ami.debian=admin"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	m := regexp.MustCompile(`comments and order of keys are not kept. \(backup: (.+)\)`).FindStringSubmatch(a.Out.String())
+	if m == nil {
+		t.Fatalf("expected backup message but %q", a.Out.String())
+	}
+
+	if backup, err := os.ReadFile(m[1]); err != nil || string(backup) != subTable {
+		t.Errorf("expected backup of original config but %q %v", backup, err)
+	}
+
+	conf, _, err := ReadConfigFile(filepath.Join(a.RnsshDir, "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if conf.Default.SshUserByAMI["ubuntu"] != "ubuntu" || conf.Default.SshUserByAMI["debian"] != "admin" {
+		t.Errorf("unexpected ssh_user_by_ami: %v", conf.Default.SshUserByAMI)
+	}
+}
+
+func TestAppRunConfigSetSymlink(t *testing.T) {
+	a := newTestApp(t, "", "")
+
+	// config in dotfiles repo
+	target := filepath.Join(t.TempDir(), "rnssh_config")
+	if err := os.WriteFile(target, []byte("version = 2\n\n[Default]\n  aws_region = \"us-west-2\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(a.RnsshDir, "config")
+	os.Remove(link)
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := a.Run([]string{"config", "set", "ssh_user=ec2-user"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if fi, err := os.Lstat(link); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected config is still symlink but %v, %v", fi, err)
+	}
+
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), `ssh_user = "ec2-user"`) {
+		t.Errorf("expected link target is updated but %s", content)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// current config schema version. add configMigrations when config keys are changed.
+const CONFIG_VERSION = 2
+
+// configMigration migrates a config table (Default and each profile) from Version to Version+1.
+// it returns descriptions of changes.
+type configMigration struct {
+	Version int
+	Migrate func(table map[string]interface{}) []string
+}
+
+var configMigrations = []configMigration{
+	// version 1: identity file key was misspelled.
+	{Version: 1, Migrate: func(table map[string]interface{}) []string {
+		return renameConfigKey(table, "ssh_identitiy_file", "ssh_identity_file")
+	}},
+}
+
+// current key -> old key. (for line number of validation error)
+var oldConfigKeys = map[string]string{
+	"ssh_identity_file": "ssh_identitiy_file",
+}
+
+// renameConfigKey renames old key to new key. new key wins if both exist.
+func renameConfigKey(table map[string]interface{}, old, new string) []string {
+	v, ok := table[old]
+	if !ok {
+		return nil
+	}
+	delete(table, old)
+
+	if _, exists := table[new]; exists {
+		return []string{fmt.Sprintf("%s is removed (%s is used)", old, new)}
+	}
+
+	table[new] = v
+	return []string{fmt.Sprintf("%s -> %s", old, new)}
+}
+
+// rawConfigVersion returns version of raw config. no version is 1.
+func rawConfigVersion(raw map[string]interface{}) int {
+	if v, ok := raw["version"].(int64); ok && v > 0 {
+		return int(v)
+	}
+
+	return 1
+}
+
+// MigrateRawConfig migrates raw config (decoded to map) to the current version.
+// unknown keys are kept. it returns descriptions of changes. (ex: Default: ssh_identitiy_file -> ssh_identity_file)
+func MigrateRawConfig(raw map[string]interface{}) ([]string, error) {
+	version := rawConfigVersion(raw)
+	if version > CONFIG_VERSION {
+		return nil, fmt.Errorf("config version %d is newer than this rnssh supports (%d). please update rnssh", version, CONFIG_VERSION)
+	}
+
+	changes := make([]string, 0)
+	for _, m := range configMigrations {
+		if m.Version < version {
+			continue
+		}
+
+		if t, ok := raw["Default"].(map[string]interface{}); ok {
+			for _, c := range m.Migrate(t) {
+				changes = append(changes, "Default: "+c)
+			}
+		}
+
+		profiles, _ := raw["profiles"].([]map[string]interface{})
+		for i, t := range profiles {
+			for _, c := range m.Migrate(t) {
+				changes = append(changes, fmt.Sprintf("profiles[%d]: %s", i, c))
+			}
+		}
+	}
+
+	raw["version"] = int64(CONFIG_VERSION)
+
+	return changes, nil
+}
+
+// DecodeConfig decodes config with migrating old keys in memory.
+// it returns warnings about unknown keys and old keys.
+func DecodeConfig(content []byte) (*Config, []string, error) {
+	conf, changes, unknown, err := decodeConfig(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	warnings := make([]string, 0, len(changes)+len(unknown))
+	for _, c := range changes {
+		warnings = append(warnings, fmt.Sprintf("old config key: %s. run `rnssh config migrate` to update", c))
+	}
+
+	for _, k := range unknown {
+		warnings = append(warnings, fmt.Sprintf("unknown config key: %s", k))
+	}
+
+	return conf, warnings, nil
+}
+
+// decodeConfig returns migrated config, changes of migration and unknown keys. (ex: Default.foo, profiles.foo)
+func decodeConfig(content []byte) (*Config, []string, []string, error) {
+	// type errors with line number of the content.
+	if _, err := toml.Decode(string(content), &Config{}); err != nil {
+		return nil, nil, nil, err
+	}
+
+	raw := make(map[string]interface{})
+	if _, err := toml.Decode(string(content), &raw); err != nil {
+		return nil, nil, nil, err
+	}
+
+	changes, err := MigrateRawConfig(raw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	migrated := &bytes.Buffer{}
+	if err := toml.NewEncoder(migrated).Encode(raw); err != nil {
+		return nil, nil, nil, err
+	}
+
+	conf := Config{}
+	md, err := toml.Decode(migrated.String(), &conf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	unknown := make([]string, 0)
+	for _, k := range md.Undecoded() {
+		unknown = append(unknown, k.String())
+	}
+	conf.defaultKeys, conf.profileKeys = definedConfigKeys(md)
+
+	return &conf, changes, unknown, nil
+}
+
+// definedConfigKeys returns keys of Default and each profile that are defined in the content.
+// md.Keys() is in order of the content, and each [[profiles]] starts with "profiles" key.
+func definedConfigKeys(md toml.MetaData) (map[string]bool, []map[string]bool) {
+	defaultKeys := make(map[string]bool)
+	profileKeys := make([]map[string]bool, 0)
+	for _, k := range md.Keys() {
+		switch {
+		case len(k) == 1 && k[0] == "profiles":
+			profileKeys = append(profileKeys, make(map[string]bool))
+		case len(k) < 2:
+		case k[0] == "Default":
+			defaultKeys[k[1]] = true
+		case k[0] == "profiles" && len(profileKeys) > 0:
+			profileKeys[len(profileKeys)-1][k[1]] = true
+		}
+	}
+
+	return defaultKeys, profileKeys
+}
+
+// ReadConfigFile reads config file. see DecodeConfig.
+func ReadConfigFile(path string) (*Config, []string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conf, warnings, err := DecodeConfig(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can not read config %s: %s", path, err.Error())
+	}
+
+	return conf, warnings, nil
+}
+
+// MigrateConfigFile rewrites config file to the current version. original file is copied to backup.
+// it returns backup path (empty is not changed) and descriptions of changes.
+func MigrateConfigFile(path string, now time.Time) (string, []string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := make(map[string]interface{})
+	if _, err := toml.Decode(string(content), &raw); err != nil {
+		return "", nil, fmt.Errorf("can not read config %s: %s", path, err.Error())
+	}
+
+	_, hasVersion := raw["version"]
+	before := rawConfigVersion(raw)
+	changes, err := MigrateRawConfig(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if hasVersion && before == CONFIG_VERSION && len(changes) == 0 {
+		return "", nil, nil
+	}
+	changes = append([]string{fmt.Sprintf("version %d -> %d", before, CONFIG_VERSION)}, changes...)
+
+	backup := fmt.Sprintf("%s.bak.%s", path, now.Format("20060102150405"))
+	if err := writeFileAtomic(backup, func(w io.Writer) error {
+		_, err := w.Write(content)
+		return err
+	}); err != nil {
+		return "", nil, fmt.Errorf("can not backup config: %s", err.Error())
+	}
+
+	if err := writeFileAtomic(path, func(w io.Writer) error {
+		return toml.NewEncoder(w).Encode(raw)
+	}); err != nil {
+		return "", nil, err
+	}
+
+	return backup, changes, nil
+}
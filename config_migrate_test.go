@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	cases := []struct {
+		name         string
+		content      string
+		identityFile string
+		profileFile  string
+		warnings     []string
+	}{
+		{
+			name: "old key",
+			content: `[Default]
+  ssh_identitiy_file = "~/.ssh/old.pem"
+
+[[profiles]]
+  profile_name = "stg"
+  ssh_identitiy_file = "~/.ssh/stg.pem"
+`,
+			identityFile: "~/.ssh/old.pem",
+			profileFile:  "~/.ssh/stg.pem",
+			warnings: []string{
+				"old config key: Default: ssh_identitiy_file -> ssh_identity_file. run `rnssh config migrate` to update",
+				"old config key: profiles[0]: ssh_identitiy_file -> ssh_identity_file. run `rnssh config migrate` to update",
+			},
+		},
+		{
+			name: "current key without version",
+			content: `[Default]
+  ssh_identity_file = "~/.ssh/new.pem"
+`,
+			identityFile: "~/.ssh/new.pem",
+		},
+		{
+			name: "both keys",
+			content: `[Default]
+  ssh_identitiy_file = "~/.ssh/old.pem"
+  ssh_identity_file = "~/.ssh/new.pem"
+`,
+			identityFile: "~/.ssh/new.pem",
+			warnings: []string{
+				"old config key: Default: ssh_identitiy_file is removed (ssh_identity_file is used). run `rnssh config migrate` to update",
+			},
+		},
+		{
+			name: "unknown keys",
+			content: `version = 2
+
+[Default]
+  ssh_identitiy_file = "~/.ssh/old.pem"
+  ssh_usr = "deploy"
+`,
+			warnings: []string{
+				"unknown config key: Default.ssh_identitiy_file",
+				"unknown config key: Default.ssh_usr",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf, warnings, err := DecodeConfig([]byte(c.content))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if conf.Default.SshIdentityFile != c.identityFile {
+				t.Errorf("expected identity file %s but %s", c.identityFile, conf.Default.SshIdentityFile)
+			}
+
+			if c.profileFile != "" && (len(conf.Profiles) != 1 || conf.Profiles[0].SshIdentityFile != c.profileFile) {
+				t.Errorf("expected profile identity file %s but %+v", c.profileFile, conf.Profiles)
+			}
+
+			if strings.Join(warnings, "\n") != strings.Join(c.warnings, "\n") {
+				t.Errorf("expected warnings %q but %q", c.warnings, warnings)
+			}
+		})
+	}
+
+	if _, _, err := DecodeConfig([]byte("version = 99\n")); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("expected newer version error but %v", err)
+	}
+}
+
+func TestAppRunConfigMigrate(t *testing.T) {
+	key := filepath.Join(t.TempDir(), "old.pem")
+	if err := os.WriteFile(key, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	original := `[Default]
+  aws_region = "us-west-2"
+  ssh_identitiy_file = "` + key + `"
+  my_note = "keep"
+`
+	a := newTestApp(t, "", original)
+	path := filepath.Join(a.RnsshDir, "config")
+
+	// old key is used with warning
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	for _, s := range []string{"warn: " + path + ": old config key: Default: ssh_identitiy_file -> ssh_identity_file", "warn: " + path + ": unknown config key: Default.my_note", key} {
+		if !strings.Contains(a.Out.String(), s) {
+			t.Errorf("expected %q but %q", s, a.Out.String())
+		}
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "migrate"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	backups, _ := filepath.Glob(path + ".bak.*")
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup but %v", backups)
+	}
+
+	if b, _ := os.ReadFile(backups[0]); string(b) != original {
+		t.Errorf("expected backup is original but %q", string(b))
+	}
+
+	migrated, _ := os.ReadFile(path)
+	for _, s := range []string{"version = 2", `ssh_identity_file = "` + key + `"`, `my_note = "keep"`} {
+		if !strings.Contains(string(migrated), s) {
+			t.Errorf("expected migrated config contains %q but %q", s, string(migrated))
+		}
+	}
+
+	if strings.Contains(string(migrated), "ssh_identitiy_file") {
+		t.Errorf("expected old key is removed but %q", string(migrated))
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "migrate"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "already version 2") {
+		t.Errorf("expected no migration but %q", a.Out.String())
+	}
+
+	// unknown key is error of validate
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "validate"}); code == 0 {
+		t.Errorf("expected error of unknown key but exit code 0")
+	}
+
+	if !regexp.MustCompile(`:[1-9][0-9]*: unknown config key: Default.my_note`).MatchString(a.Out.String()) {
+		t.Errorf("expected unknown key error with line but %q", a.Out.String())
+	}
+}
+
+func TestMigrateConfigFileBackupName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("[Default]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2026, 10, 19, 12, 34, 56, 0, time.Local)
+	backup, changes, err := MigrateConfigFile(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if backup != path+".bak.20261019123456" {
+		t.Errorf("unexpected backup path: %s", backup)
+	}
+
+	if len(changes) != 1 || changes[0] != "version 1 -> 2" {
+		t.Errorf("unexpected changes: %v", changes)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConfigProfile(t *testing.T) {
+	content := `[Default]
+  aws_region = "us-west-2"
+  ssh_user = "deploy"
+  ssh_port = 2222
+  use_ssh_config = true
+  ssh_strict_host_key_checking_no = 1
+  ssh_agent = true
+
+[[profiles]]
+  profile_name = "prod"
+  ssh_user = "admin"
+  ssh_port = 0
+  use_ssh_config = false
+  ssh_strict_host_key_checking_no = 0
+  ssh_agent = false
+
+[[profiles]]
+  profile_name = "stg"
+  aws_region = "eu-west-1"
+`
+	conf, _, err := DecodeConfig([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		profile  string
+		expected RnsshConfig
+	}{
+		{
+			profile:  "",
+			expected: RnsshConfig{AWSRegion: "us-west-2", SshUser: "deploy", SshPort: 2222, UseSshConfig: true, SshStrictHostKeyCheckingNo: 1, SshAgent: true},
+		},
+		{
+			// defined zero values override Default.
+			profile:  "prod",
+			expected: RnsshConfig{Name: "prod", AWSRegion: "us-west-2", SshUser: "admin"},
+		},
+		{
+			profile:  "stg",
+			expected: RnsshConfig{Name: "stg", AWSRegion: "eu-west-1", SshUser: "deploy", SshPort: 2222, UseSshConfig: true, SshStrictHostKeyCheckingNo: 1, SshAgent: true},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.profile, func(t *testing.T) {
+			p, err := conf.Profile(c.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.AWSRegion != c.expected.AWSRegion || p.SshUser != c.expected.SshUser || p.SshPort != c.expected.SshPort ||
+				p.UseSshConfig != c.expected.UseSshConfig || p.SshStrictHostKeyCheckingNo != c.expected.SshStrictHostKeyCheckingNo || p.SshAgent != c.expected.SshAgent {
+				t.Errorf("expected %+v but %+v", c.expected, *p)
+			}
+		})
+	}
+}
+
+func TestProfileNameCheck(t *testing.T) {
+	cases := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "", valid: true},
+		{name: "prod", valid: true},
+		{name: "prod.v2", valid: true},
+		{name: "../../.ssh/authorized_keys", valid: false},
+		{name: "team/prod", valid: false},
+		{name: `team\prod`, valid: false},
+		{name: "..", valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := ProfileNameCheck(c.name)
+			if c.valid && err != nil {
+				t.Errorf("expected valid but %v", err)
+			}
+
+			if !c.valid && err == nil {
+				t.Errorf("expected error but nil")
+			}
+		})
+	}
+
+	conf := &Config{Profiles: []RnsshConfig{{Name: "../prod"}}}
+	if err := conf.Validate(); err == nil {
+		t.Errorf("expected config with invalid profile name is error")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/reiki4040/peco"
+)
+
+// Describe returns full details of the instance for preview pane.
+func (e *ChoosableEC2) Describe() string {
+	w := new(tabwriter.Writer)
+	var b bytes.Buffer
+	w.Init(&b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(w, "InstanceId:\t%s\n", e.InstanceId)
+	fmt.Fprintf(w, "Name:\t%s\n", e.Name)
+	if e.Unavailable != "" {
+		fmt.Fprintf(w, "Unavailable:\t%s\n", e.Unavailable)
+	} else {
+		fmt.Fprintf(w, "SshHost:\t%s (%s)\n", e.Value(), e.TargetType)
+	}
+	fmt.Fprintf(w, "InstanceType:\t%s\n", e.InstanceType)
+	fmt.Fprintf(w, "AvailabilityZone:\t%s\n", e.AvailabilityZone)
+	fmt.Fprintf(w, "VPC:\t%s\n", e.VpcId)
+	fmt.Fprintf(w, "Subnet:\t%s\n", e.SubnetId)
+	fmt.Fprintf(w, "PublicIP:\t%s\n", e.PublicIP)
+	fmt.Fprintf(w, "PrivateIP:\t%s\n", e.PrivateIP)
+	if !e.LaunchTime.IsZero() {
+		fmt.Fprintf(w, "LaunchTime:\t%s\n", e.LaunchTime.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "AMI:\t%s %s\n", e.ImageId, e.ImageName)
+	fmt.Fprintf(w, "Platform:\t%s\n", e.PlatformDetails)
+	fmt.Fprintf(w, "KeyName:\t%s\n", e.KeyName)
+
+	fmt.Fprintf(w, "SecurityGroups:\t\n")
+	for _, g := range e.SecurityGroups {
+		fmt.Fprintf(w, "  %s\t\n", g)
+	}
+
+	fmt.Fprintf(w, "NetworkInterfaces:\t\n")
+	for _, n := range e.Interfaces {
+		fmt.Fprintf(w, "  eth%d\t%s %s %s %s\n", n.DeviceIndex, n.NetworkInterfaceId, n.SubnetId, n.PublicIP, strings.Join(n.PrivateIPs, ","))
+	}
+
+	keys := make([]string, 0, len(e.Tags))
+	for k := range e.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "Tags:\t\n")
+	for _, k := range keys {
+		fmt.Fprintf(w, "  %s\t%s\n", k, e.Tags[k])
+	}
+
+	w.Flush()
+	return b.String()
+}
+
+// DescribeChoosable returns details of the item. it is used by builtin selector preview.
+func DescribeChoosable(c peco.Choosable) string {
+	if e, ok := c.(*ChoosableEC2); ok {
+		return e.Describe()
+	}
+
+	return c.Choice()
+}
+
+// DescribeCachedInstance finds the instance from cache. (not connect to AWS)
+func (r *EC2Handler) DescribeCachedInstance(region, hostType, netIf, instanceId string) (*ChoosableEC2, error) {
+	is, err := r.LoadCache(region)
+	if err != nil {
+		if os.IsNotExist(err) || err == ErrCacheVersion {
+			return nil, fmt.Errorf("there is no cache of %s. please reload with -f", region)
+		}
+		return nil, err
+	}
+
+	for _, c := range ConvertChoosableList(is.Instances, is.ImageNames, hostType, netIf) {
+		if e, ok := c.(*ChoosableEC2); ok && e.InstanceId == instanceId {
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not found in cache of %s", instanceId, region)
+}
+
+// genPreviewCommand returns command for external finder preview.
+// {1} is the first field (instance id) of highlighted line.
+// preview runs with the same profile and endpoint, and does not prompt to trust project config.
+func genPreviewCommand(rOpt *RnsshOption) string {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = "rnssh"
+	}
+
+	cmd := fmt.Sprintf("%s -describe {1} -no-trust-prompt -r %s", shellQuote(exe), shellQuote(rOpt.Region))
+	if rOpt.Profile != "" {
+		cmd += " -profile " + shellQuote(rOpt.Profile)
+	}
+
+	if rOpt.EC2Endpoint != "" {
+		cmd += " -ec2-endpoint " + shellQuote(rOpt.EC2Endpoint)
+	}
+
+	return cmd
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
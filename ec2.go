@@ -4,9 +4,15 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
@@ -17,6 +23,18 @@ import (
 
 const (
 	RNSSH_EC2_LIST_CACHE_PREFIX = "aws.instances.cache."
+
+	// cache schema version. increment it when Instances format is changed, then old cache is reloaded.
+	INSTANCES_CACHE_VERSION = 2
+
+	// region for DescribeRegions when AWS_REGION is not set.
+	DEFAULT_API_REGION = "us-east-1"
+
+	// DescribeRegions is for wizard, so give up early without credentials.
+	DESCRIBE_REGIONS_TIMEOUT = 5 * time.Second
+
+	// max values of one filter in DescribeImages.
+	DESCRIBE_IMAGES_FILTER_MAX = 200
 )
 
 type ChoosableEC2 struct {
@@ -25,6 +43,48 @@ type ChoosableEC2 struct {
 	PublicIP   string
 	PrivateIP  string
 	TargetType string
+
+	// all network interfaces of the instance. PublicIP/PrivateIP are chosen interface's address.
+	Interfaces []NetworkInterface
+
+	// device index of chosen network interface. -1 is instance primary address.
+	InterfaceIndex int32
+
+	Tags            map[string]string
+	ImageId         string
+	ImageName       string
+	PlatformDetails string
+	Platform        string
+	KeyName         string
+
+	InstanceType     string
+	AvailabilityZone string
+	VpcId            string
+	SubnetId         string
+	SecurityGroups   []string
+	LaunchTime       time.Time
+
+	// reason of the instance has no address for any host types.
+	Unavailable string
+}
+
+type NetworkInterface struct {
+	NetworkInterfaceId string `json:"id"`
+	DeviceIndex        int32  `json:"device_index"`
+	SubnetId           string `json:"subnet_id,omitempty"`
+	Description        string `json:"description,omitempty"`
+	PublicIP           string `json:"public_ip,omitempty"`
+
+	// primary private IP is first, then secondary private IPs.
+	PrivateIPs []string `json:"private_ips,omitempty"`
+}
+
+func (n *NetworkInterface) PrivateIP() string {
+	if len(n.PrivateIPs) == 0 {
+		return ""
+	}
+
+	return n.PrivateIPs[0]
 }
 
 func (e *ChoosableEC2) Choice() string {
@@ -36,10 +96,22 @@ func (e *ChoosableEC2) Choice() string {
 	w := new(tabwriter.Writer)
 	var b bytes.Buffer
 	w.Init(&b, 14, 0, 4, ' ', 0)
-	if e.TargetType == HOST_TYPE_NAME_TAG {
+	if e.Unavailable != "" {
+		fmt.Fprintf(w, "%s\t%s\t(unavailable: %s)", e.InstanceId, e.Name, e.Unavailable)
+		w.Flush()
+		return b.String()
+	} else if e.TargetType == HOST_TYPE_NAME_TAG {
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s", e.InstanceId, e.Name, publicIP, e.PrivateIP)
 		w.Flush()
 		return b.String()
+	} else if e.TargetType == HOST_TYPE_SSM {
+		fmt.Fprintf(w, "%s\t%s\t%s", e.InstanceId, e.Name, "(ssm)")
+		w.Flush()
+		return b.String()
+	} else if e.InterfaceIndex > 0 {
+		fmt.Fprintf(w, "%s\t%s\t%s (eth%d)", e.InstanceId, e.Name, e.Value(), e.InterfaceIndex)
+		w.Flush()
+		return b.String()
 	} else {
 		fmt.Fprintf(w, "%s\t%s\t%s", e.InstanceId, e.Name, e.Value())
 		w.Flush()
@@ -55,11 +127,17 @@ func (e *ChoosableEC2) Value() string {
 		return e.PrivateIP
 	case HOST_TYPE_NAME_TAG:
 		return e.Name
+	case HOST_TYPE_SSM:
+		return e.InstanceId
 	default:
 		return ""
 	}
 }
 
+func (e *ChoosableEC2) IsWindows() bool {
+	return strings.EqualFold(e.Platform, string(types.PlatformValuesWindows))
+}
+
 type ChoosableEC2s []*ChoosableEC2
 
 func (e ChoosableEC2s) Len() int {
@@ -71,52 +149,185 @@ func (e ChoosableEC2s) Swap(i, j int) {
 }
 
 func (e ChoosableEC2s) Less(i, j int) bool {
+	// unavailable instances are shown after available instances.
+	iu, ju := e[i].Unavailable != "", e[j].Unavailable != ""
+	if iu != ju {
+		return ju
+	}
+
 	return e[i].Name < e[j].Name
 }
 
 type Instances struct {
-	Instances []*types.Instance `json:"ec2_instances"`
+	Version   int               `json:"version"`
+	Instances []*CachedInstance `json:"instances"`
+
+	// AMI id -> AMI name
+	ImageNames map[string]string `json:"ec2_image_names,omitempty"`
+}
+
+// CachedInstance is compact record of the instance that has only fields rnssh uses.
+// it is stored in cache instead of SDK type, so cache is small and does not depend on SDK JSON shape.
+type CachedInstance struct {
+	InstanceId        string             `json:"id"`
+	State             string             `json:"state"`
+	Tags              map[string]string  `json:"tags,omitempty"`
+	PublicIP          string             `json:"public_ip,omitempty"`
+	PrivateIP         string             `json:"private_ip,omitempty"`
+	NetworkInterfaces []NetworkInterface `json:"network_interfaces,omitempty"`
+	ImageId           string             `json:"image_id,omitempty"`
+	PlatformDetails   string             `json:"platform_details,omitempty"`
+	Platform          string             `json:"platform,omitempty"`
+	KeyName           string             `json:"key_name,omitempty"`
+	InstanceType      string             `json:"instance_type,omitempty"`
+	AvailabilityZone  string             `json:"availability_zone,omitempty"`
+	VpcId             string             `json:"vpc_id,omitempty"`
+	SubnetId          string             `json:"subnet_id,omitempty"`
+	SecurityGroups    []string           `json:"security_groups,omitempty"`
+	LaunchTime        time.Time          `json:"launch_time"`
 }
 
-func NewEC2Handler(m *cstore.Manager) *EC2Handler {
-	return &EC2Handler{
-		Manager: m,
+func NewCachedInstance(i *types.Instance) *CachedInstance {
+	c := &CachedInstance{
+		InstanceId:        convertNilString(i.InstanceId),
+		Tags:              convertTags(i.Tags),
+		PublicIP:          convertNilString(i.PublicIpAddress),
+		PrivateIP:         convertNilString(i.PrivateIpAddress),
+		NetworkInterfaces: convertNetworkInterfaces(i.NetworkInterfaces),
+		ImageId:           convertNilString(i.ImageId),
+		PlatformDetails:   convertNilString(i.PlatformDetails),
+		Platform:          string(i.Platform),
+		KeyName:           convertNilString(i.KeyName),
+		InstanceType:      string(i.InstanceType),
+		VpcId:             convertNilString(i.VpcId),
+		SubnetId:          convertNilString(i.SubnetId),
+	}
+
+	if i.State != nil {
+		c.State = string(i.State.Name)
 	}
+
+	if i.Placement != nil {
+		c.AvailabilityZone = convertNilString(i.Placement.AvailabilityZone)
+	}
+
+	if i.LaunchTime != nil {
+		c.LaunchTime = *i.LaunchTime
+	}
+
+	for _, g := range i.SecurityGroups {
+		c.SecurityGroups = append(c.SecurityGroups, fmt.Sprintf("%s (%s)", convertNilString(g.GroupId), convertNilString(g.GroupName)))
+	}
+
+	return c
 }
 
-type EC2Handler struct {
-	Manager *cstore.Manager
+func NewCachedInstances(instances []*types.Instance) []*CachedInstance {
+	cached := make([]*CachedInstance, 0, len(instances))
+	for _, i := range instances {
+		cached = append(cached, NewCachedInstance(i))
+	}
+
+	return cached
+}
+
+// EC2API is EC2 operations that rnssh uses. *ec2.Client satisfies it.
+// tests and local EC2 compatible stand-in replace it.
+type EC2API interface {
+	DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
+	GetPasswordData(ctx context.Context, params *ec2.GetPasswordDataInput, optFns ...func(*ec2.Options)) (*ec2.GetPasswordDataOutput, error)
+	DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error)
+	GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error)
+}
+
+// GetRegions returns region names that are enabled in the account.
+func GetRegions(client EC2API) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DESCRIBE_REGIONS_TIMEOUT)
+	defer cancel()
+
+	out, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]string, 0, len(out.Regions))
+	for _, r := range out.Regions {
+		if name := aws.ToString(r.RegionName); name != "" {
+			regions = append(regions, name)
+		}
+	}
+
+	return regions, nil
 }
 
-func (r *EC2Handler) GetCacheStore(region string) (*cstore.CStore, error) {
-	cacheFileName := RNSSH_EC2_LIST_CACHE_PREFIX + region + ".json"
-	return r.Manager.New(cacheFileName, cstore.JSON)
+// NewEC2Client creates EC2 client. if endpoint is not empty, connect to it instead of AWS.
+func NewEC2Client(region, endpoint string) (EC2API, error) {
+	ctx := context.TODO()
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	cli := ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	return cli, nil
+}
+
+type EC2Handler struct {
+	// instances cache dir. (~/.rnssh)
+	CacheDir  string
+	NewClient func(region string) (EC2API, error)
+
+	// cache older than this is reloaded. 0 is not expired.
+	CacheTTL time.Duration
+
+	// host keys of instances that are removed from cache are removed from this. empty is not cleaned up.
+	KnownHostsFile string
+
+	// warning message output. nil is stderr.
+	Warn io.Writer
 }
 
-func (r *EC2Handler) LoadTargetHost(hostType string, region string, reload bool) ([]peco.Choosable, error) {
-	var instances []*types.Instance
-	cacheStore, _ := r.GetCacheStore(region)
+func (r *EC2Handler) warnf(format string, a ...interface{}) {
+	w := r.Warn
+	if w == nil {
+		w = os.Stderr
+	}
+
+	fmt.Fprintf(w, "warn: "+format+"\n", a...)
+}
 
+// LoadCache loads instances from cache file without connecting to AWS.
+func (r *EC2Handler) LoadCache(region string) (*Instances, error) {
 	is := Instances{}
-	if cErr := cacheStore.GetWithoutValidate(&is); cErr != nil || reload {
+	if err := cstore.LoadFromJsonFile(cacheFilePath(r.CacheDir, region), &is); err != nil {
+		return nil, err
+	}
+
+	if is.Version != INSTANCES_CACHE_VERSION {
+		return nil, ErrCacheVersion
+	}
+
+	return &is, nil
+}
+
+func (r *EC2Handler) LoadTargetHost(hostType, netIf string, region string, reload bool) ([]peco.Choosable, error) {
+	// old format cache and expired cache are reloaded.
+	is, cErr := r.LoadCache(region)
+	if cErr != nil || reload || r.cacheExpired(region) {
 		var err error
-		instances, err = GetInstances(region)
+		is, err = r.RefreshCache(region)
 		if err != nil {
-			awsErr := fmt.Errorf("failed get instance: %s", err.Error())
-			return nil, awsErr
-		}
-
-		is = Instances{Instances: instances}
-		if cacheStore != nil {
-			err := cacheStore.SaveWithoutValidate(&is)
-			if err != nil {
-				// only warn message
-				fmt.Printf("warn: failed store ec2 list cache: %s\n", err.Error())
-			}
+			return nil, err
 		}
 	}
 
-	choices := ConvertChoosableList(is.Instances, hostType)
+	choices := ConvertChoosableList(is.Instances, is.ImageNames, hostType, netIf)
 	if len(choices) == 0 {
 		err := fmt.Errorf("there is no running instance")
 		return nil, err
@@ -125,38 +336,178 @@ func (r *EC2Handler) LoadTargetHost(hostType string, region string, reload bool)
 	return choices, nil
 }
 
-func GetInstances(region string) ([]*types.Instance, error) {
-	ctx := context.TODO()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+func (r *EC2Handler) cacheExpired(region string) bool {
+	if r.CacheTTL <= 0 {
+		return false
+	}
+
+	st, err := os.Stat(cacheFilePath(r.CacheDir, region))
 	if err != nil {
-		return nil, err
+		return true
 	}
-	cli := ec2.NewFromConfig(cfg)
 
-	resp, err := cli.DescribeInstances(ctx, nil)
+	return time.Since(st.ModTime()) > r.CacheTTL
+}
+
+// RefreshCache gets instances from AWS and saves cache.
+// concurrent refresh (other rnssh process) waits the first one with lock, then uses its result instead of connecting to AWS.
+func (r *EC2Handler) RefreshCache(region string) (*Instances, error) {
+	path := cacheFilePath(r.CacheDir, region)
+
+	start := time.Now()
+	unlock, err := lockCache(path)
 	if err != nil {
-		return nil, err
+		// only warn message. refresh without lock.
+		r.warnf("can not lock cache: %s", err.Error())
+	} else {
+		defer unlock()
+
+		if st, err := os.Stat(path); err == nil && st.ModTime().After(start) {
+			if is, err := r.LoadCache(region); err == nil {
+				return is, nil
+			}
+		}
+	}
+
+	sdkInstances, err := r.GetInstances(region)
+	if err != nil {
+		awsErr := fmt.Errorf("failed get instance: %s", err.Error())
+		return nil, awsErr
 	}
+	instances := NewCachedInstances(sdkInstances)
+
+	imageNames, err := r.GetImageNames(region, instances)
+	if err != nil {
+		// only warn message. AMI name is used for detecting ssh user.
+		r.warnf("failed get AMI names: %s", err.Error())
+	}
+
+	is := &Instances{Version: INSTANCES_CACHE_VERSION, Instances: instances, ImageNames: imageNames}
+	old, _ := r.LoadCache(region)
+	if err := SaveCache(path, is); err != nil {
+		// only warn message
+		r.warnf("failed store ec2 list cache: %s", err.Error())
+	} else if old != nil {
+		r.cleanKnownHosts(old, is)
+	}
+
+	return is, nil
+}
+
+// cleanKnownHosts removes host keys of instances that are in old cache but not in new cache.
+func (r *EC2Handler) cleanKnownHosts(old, new *Instances) {
+	if r.KnownHostsFile == "" {
+		return
+	}
+
+	exists := make(map[string]bool, len(new.Instances))
+	for _, i := range new.Instances {
+		exists[i.InstanceId] = true
+	}
+
+	removed := make([]string, 0)
+	for _, i := range old.Instances {
+		if !exists[i.InstanceId] {
+			removed = append(removed, i.InstanceId)
+		}
+	}
+
+	if _, err := RemoveKnownHosts(r.KnownHostsFile, removed); err != nil {
+		// only warn message
+		r.warnf("failed clean up known_hosts: %s", err.Error())
+	}
+}
 
-	if len(resp.Reservations) == 0 {
-		return []*types.Instance{}, nil
+func (r *EC2Handler) GetInstances(region string) ([]*types.Instance, error) {
+	cli, err := r.NewClient(region)
+	if err != nil {
+		return nil, err
 	}
 
 	instances := make([]*types.Instance, 0)
-	for _, r := range resp.Reservations {
-		for _, i := range r.Instances {
-			instances = append(instances, &i)
+	p := ec2.NewDescribeInstancesPaginator(cli, &ec2.DescribeInstancesInput{})
+	for p.HasMorePages() {
+		resp, err := p.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range resp.Reservations {
+			for _, i := range r.Instances {
+				instances = append(instances, &i)
+			}
 		}
 	}
 
 	return instances, nil
 }
 
-func ConvertChoosableList(instances []*types.Instance, targetType string) []peco.Choosable {
+func (r *EC2Handler) GetImageNames(region string, instances []*CachedInstance) (map[string]string, error) {
+	imageNames := make(map[string]string)
+
+	idMap := make(map[string]bool)
+	imageIds := make([]string, 0)
+	for _, i := range instances {
+		id := i.ImageId
+		if id != "" && !idMap[id] {
+			idMap[id] = true
+			imageIds = append(imageIds, id)
+		}
+	}
+
+	if len(imageIds) == 0 {
+		return imageNames, nil
+	}
+
+	cli, err := r.NewClient(region)
+	if err != nil {
+		return nil, err
+	}
+
+	// ImageIds fails all if one of AMIs is deregistered or unshared, but filter skips them.
+	for start := 0; start < len(imageIds); start += DESCRIBE_IMAGES_FILTER_MAX {
+		ids := imageIds[start:min(start+DESCRIBE_IMAGES_FILTER_MAX, len(imageIds))]
+		resp, err := cli.DescribeImages(context.TODO(), &ec2.DescribeImagesInput{
+			Filters: []types.Filter{{Name: aws.String("image-id"), Values: ids}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, img := range resp.Images {
+			imageNames[convertNilString(img.ImageId)] = convertNilString(img.Name)
+		}
+	}
+
+	return imageNames, nil
+}
+
+// GetPasswordData returns encrypted(base64) administrator password of windows instance.
+func (r *EC2Handler) GetPasswordData(region, instanceId string) (string, error) {
+	cli, err := r.NewClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cli.GetPasswordData(context.TODO(), &ec2.GetPasswordDataInput{InstanceId: &instanceId})
+	if err != nil {
+		return "", err
+	}
+
+	return convertNilString(resp.PasswordData), nil
+}
+
+// ConvertChoosableList converts running instances to choosable list.
+// hostType is ordered fallback list (ex: "public,private,ssm"),
+// each instance uses the first host type that it has.
+// netIf chooses network interface that has ssh address. (see NetworkInterfaceCheck)
+func ConvertChoosableList(instances []*CachedInstance, imageNames map[string]string, hostType, netIf string) []peco.Choosable {
+	hostTypes := ParseHostTypes(hostType)
 	choosableEC2List := make([]*ChoosableEC2, 0, len(instances))
 	for _, i := range instances {
-		e := convertChoosable(i, targetType)
+		e := convertChoosable(i, hostTypes, netIf)
 		if e != nil {
+			e.ImageName = imageNames[e.ImageId]
 			choosableEC2List = append(choosableEC2List, e)
 		}
 	}
@@ -171,37 +522,132 @@ func ConvertChoosableList(instances []*types.Instance, targetType string) []peco
 	return choices
 }
 
-func convertChoosable(i *types.Instance, targetType string) *ChoosableEC2 {
-	if i.State.Name != types.InstanceStateNameRunning {
+func convertChoosable(i *CachedInstance, hostTypes []string, netIf string) *ChoosableEC2 {
+	if i.State != string(types.InstanceStateNameRunning) {
 		return nil
 	}
 
-	var nameTag string
-	for _, tag := range i.Tags {
-		if convertNilString(tag.Key) == "Name" {
-			nameTag = convertNilString(tag.Value)
-			break
-		}
+	ec2host := &ChoosableEC2{
+		InstanceId:       i.InstanceId,
+		Name:             i.Tags["Name"],
+		PublicIP:         i.PublicIP,
+		PrivateIP:        i.PrivateIP,
+		Interfaces:       i.NetworkInterfaces,
+		InterfaceIndex:   -1,
+		Tags:             i.Tags,
+		ImageId:          i.ImageId,
+		PlatformDetails:  i.PlatformDetails,
+		Platform:         i.Platform,
+		KeyName:          i.KeyName,
+		InstanceType:     i.InstanceType,
+		AvailabilityZone: i.AvailabilityZone,
+		VpcId:            i.VpcId,
+		SubnetId:         i.SubnetId,
+		SecurityGroups:   i.SecurityGroups,
+		LaunchTime:       i.LaunchTime,
 	}
 
-	ins := *i
+	if netIf != "" {
+		n := chooseNetworkInterface(ec2host.Interfaces, netIf, i.Tags)
+		if n == nil {
+			ec2host.Unavailable = fmt.Sprintf("no network interface matched %s", netIf)
+			return ec2host
+		}
 
-	ec2host := &ChoosableEC2{
-		InstanceId: convertNilString(ins.InstanceId),
-		Name:       nameTag,
-		PublicIP:   convertNilString(ins.PublicIpAddress),
-		PrivateIP:  convertNilString(ins.PrivateIpAddress),
-		TargetType: targetType,
+		ec2host.InterfaceIndex = n.DeviceIndex
+		ec2host.PublicIP = n.PublicIP
+		ec2host.PrivateIP = n.PrivateIP()
 	}
 
-	t := ec2host.Value()
-	if t == "" {
-		return nil
+	for _, t := range hostTypes {
+		ec2host.TargetType = t
+		if ec2host.Value() != "" {
+			return ec2host
+		}
 	}
 
+	// keep the instance in list with reason instead of hiding it.
+	ec2host.TargetType = ""
+	ec2host.Unavailable = fmt.Sprintf("no %s address", strings.Join(hostTypes, "/"))
+
 	return ec2host
 }
 
+func convertNetworkInterfaces(nis []types.InstanceNetworkInterface) []NetworkInterface {
+	interfaces := make([]NetworkInterface, 0, len(nis))
+	for _, ni := range nis {
+		n := NetworkInterface{
+			NetworkInterfaceId: convertNilString(ni.NetworkInterfaceId),
+			SubnetId:           convertNilString(ni.SubnetId),
+			Description:        convertNilString(ni.Description),
+		}
+
+		if ni.Attachment != nil && ni.Attachment.DeviceIndex != nil {
+			n.DeviceIndex = *ni.Attachment.DeviceIndex
+		}
+
+		if ni.Association != nil {
+			n.PublicIP = convertNilString(ni.Association.PublicIp)
+		}
+
+		// primary first
+		for _, p := range ni.PrivateIpAddresses {
+			if p.Primary != nil && *p.Primary {
+				n.PrivateIPs = append([]string{convertNilString(p.PrivateIpAddress)}, n.PrivateIPs...)
+			} else {
+				n.PrivateIPs = append(n.PrivateIPs, convertNilString(p.PrivateIpAddress))
+			}
+		}
+
+		if len(n.PrivateIPs) == 0 && ni.PrivateIpAddress != nil {
+			n.PrivateIPs = []string{*ni.PrivateIpAddress}
+		}
+
+		interfaces = append(interfaces, n)
+	}
+
+	sort.Slice(interfaces, func(i, j int) bool {
+		return interfaces[i].DeviceIndex < interfaces[j].DeviceIndex
+	})
+
+	return interfaces
+}
+
+// chooseNetworkInterface returns the network interface that matched netIf.
+//
+//	"1"             : device index
+//	"subnet-xxxx"   : subnet id
+//	"tag:TagKey"    : instance tag value is device index or subnet id (per instance setting)
+func chooseNetworkInterface(interfaces []NetworkInterface, netIf string, tags map[string]string) *NetworkInterface {
+	if strings.HasPrefix(netIf, NETWORK_INTERFACE_TAG_PREFIX) {
+		netIf = tags[strings.TrimPrefix(netIf, NETWORK_INTERFACE_TAG_PREFIX)]
+		if netIf == "" {
+			return nil
+		}
+	}
+
+	for i, n := range interfaces {
+		if strings.HasPrefix(netIf, NETWORK_INTERFACE_SUBNET_PREFIX) {
+			if n.SubnetId == netIf {
+				return &interfaces[i]
+			}
+		} else if idx, err := strconv.Atoi(netIf); err == nil && int32(idx) == n.DeviceIndex {
+			return &interfaces[i]
+		}
+	}
+
+	return nil
+}
+
+func convertTags(tags []types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[convertNilString(tag.Key)] = convertNilString(tag.Value)
+	}
+
+	return m
+}
+
 func convertNilString(s *string) string {
 	if s == nil {
 		return ""
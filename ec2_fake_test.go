@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fakeEC2 is in-process EC2API for tests.
+type fakeEC2 struct {
+	Instances    []types.Instance
+	Images       []types.Image
+	PasswordData map[string]string
+	Regions      []string
+	Err          error
+
+	// instance id -> console output (not encoded)
+	ConsoleOutput map[string]string
+
+	// DescribeInstances waits it if not nil. (for concurrent test)
+	Wait chan struct{}
+
+	mu                     sync.Mutex
+	DescribeInstancesCount int
+}
+
+func (f *fakeEC2) DescribeInstances(ctx context.Context, params *ec2.DescribeInstancesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.mu.Lock()
+	f.DescribeInstancesCount++
+	f.mu.Unlock()
+
+	if f.Wait != nil {
+		<-f.Wait
+	}
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	return &ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{
+			{Instances: f.Instances},
+		},
+	}, nil
+}
+
+func (f *fakeEC2) describeInstancesCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.DescribeInstancesCount
+}
+
+func (f *fakeEC2) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	// same as AWS, ImageIds fails if one of them is not found, but filter skips it.
+	known := make(map[string]bool, len(f.Images))
+	for _, img := range f.Images {
+		known[aws.ToString(img.ImageId)] = true
+	}
+	for _, id := range params.ImageIds {
+		if !known[id] {
+			return nil, fmt.Errorf("InvalidAMIID.NotFound: The image id '[%s]' does not exist", id)
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range params.ImageIds {
+		ids[id] = true
+	}
+	for _, filter := range params.Filters {
+		if aws.ToString(filter.Name) == "image-id" {
+			for _, id := range filter.Values {
+				ids[id] = true
+			}
+		}
+	}
+
+	images := make([]types.Image, 0, len(ids))
+	for _, img := range f.Images {
+		if ids[aws.ToString(img.ImageId)] {
+			images = append(images, img)
+		}
+	}
+
+	return &ec2.DescribeImagesOutput{Images: images}, nil
+}
+
+func (f *fakeEC2) GetPasswordData(ctx context.Context, params *ec2.GetPasswordDataInput, optFns ...func(*ec2.Options)) (*ec2.GetPasswordDataOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	id := aws.ToString(params.InstanceId)
+	p, ok := f.PasswordData[id]
+	if !ok {
+		return nil, fmt.Errorf("instance not found: %s", id)
+	}
+
+	return &ec2.GetPasswordDataOutput{InstanceId: params.InstanceId, PasswordData: aws.String(p)}, nil
+}
+
+func (f *fakeEC2) DescribeRegions(ctx context.Context, params *ec2.DescribeRegionsInput, optFns ...func(*ec2.Options)) (*ec2.DescribeRegionsOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	regions := make([]types.Region, 0, len(f.Regions))
+	for _, r := range f.Regions {
+		regions = append(regions, types.Region{RegionName: aws.String(r)})
+	}
+
+	return &ec2.DescribeRegionsOutput{Regions: regions}, nil
+}
+
+func (f *fakeEC2) GetConsoleOutput(ctx context.Context, params *ec2.GetConsoleOutputInput, optFns ...func(*ec2.Options)) (*ec2.GetConsoleOutputOutput, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	id := aws.ToString(params.InstanceId)
+	o, ok := f.ConsoleOutput[id]
+	if !ok {
+		return nil, fmt.Errorf("instance not found: %s", id)
+	}
+
+	return &ec2.GetConsoleOutputOutput{InstanceId: params.InstanceId, Output: aws.String(base64.StdEncoding.EncodeToString([]byte(o)))}, nil
+}
+
+func newFakeEC2Handler(cacheDir string, f *fakeEC2) *EC2Handler {
+	return &EC2Handler{
+		CacheDir: cacheDir,
+		NewClient: func(region string) (EC2API, error) {
+			return f, nil
+		},
+	}
+}
+
+type fakeInstanceOption func(i *types.Instance)
+
+func newFakeInstance(id, name string, opts ...fakeInstanceOption) types.Instance {
+	i := types.Instance{
+		InstanceId: aws.String(id),
+		State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+		Tags: []types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(name)},
+		},
+	}
+
+	for _, o := range opts {
+		o(&i)
+	}
+
+	return i
+}
+
+func withWindows(keyName string) fakeInstanceOption {
+	return func(i *types.Instance) {
+		i.Platform = types.PlatformValuesWindows
+		i.KeyName = aws.String(keyName)
+	}
+}
+
+func withPublicIP(ip string) fakeInstanceOption {
+	return func(i *types.Instance) {
+		i.PublicIpAddress = aws.String(ip)
+	}
+}
+
+func withPrivateIP(ip string) fakeInstanceOption {
+	return func(i *types.Instance) {
+		i.PrivateIpAddress = aws.String(ip)
+	}
+}
+
+func withState(state types.InstanceStateName) fakeInstanceOption {
+	return func(i *types.Instance) {
+		i.State = &types.InstanceState{Name: state}
+	}
+}
+
+func withTag(key, value string) fakeInstanceOption {
+	return func(i *types.Instance) {
+		i.Tags = append(i.Tags, types.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+}
+
+func withNetworkInterface(deviceIndex int32, subnetId, publicIP string, privateIPs ...string) fakeInstanceOption {
+	return func(i *types.Instance) {
+		ni := types.InstanceNetworkInterface{
+			Attachment: &types.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int32(deviceIndex)},
+			SubnetId:   aws.String(subnetId),
+		}
+
+		if publicIP != "" {
+			ni.Association = &types.InstanceNetworkInterfaceAssociation{PublicIp: aws.String(publicIP)}
+		}
+
+		for idx, ip := range privateIPs {
+			ni.PrivateIpAddresses = append(ni.PrivateIpAddresses, types.InstancePrivateIpAddress{
+				Primary:          aws.Bool(idx == 0),
+				PrivateIpAddress: aws.String(ip),
+			})
+		}
+
+		i.NetworkInterfaces = append(i.NetworkInterfaces, ni)
+	}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func toCachedInstances(instances []types.Instance) []*CachedInstance {
+	cached := make([]*CachedInstance, 0, len(instances))
+	for i := range instances {
+		cached = append(cached, NewCachedInstance(&instances[i]))
+	}
+
+	return cached
+}
+
+func TestConvertChoosableList(t *testing.T) {
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web", withPublicIP("203.0.113.1"), withPrivateIP("10.0.0.1")),
+		newFakeInstance("i-0002", "db", withPrivateIP("10.0.0.2")),
+		newFakeInstance("i-0003", "app", withPublicIP("203.0.113.3"), withPrivateIP("10.0.0.3"), withState(types.InstanceStateNameStopped)),
+		newFakeInstance("i-0004", "appliance", withPrivateIP("10.0.0.4"),
+			withNetworkInterface(0, "subnet-aaaa", "", "10.0.0.4"),
+			withNetworkInterface(1, "subnet-mgmt", "", "10.1.0.4", "10.1.0.5"),
+			withTag("SshInterface", "1")),
+	}
+
+	tests := []struct {
+		name     string
+		hostType string
+		netIf    string
+		// instance id -> ssh host. "" is unavailable
+		expected map[string]string
+		order    []string
+	}{
+		{
+			name:     "public only shows private only instance as unavailable",
+			hostType: "public",
+			expected: map[string]string{"i-0001": "203.0.113.1", "i-0002": "", "i-0004": ""},
+			order:    []string{"i-0001", "i-0004", "i-0002"},
+		},
+		{
+			name:     "fallback to private",
+			hostType: "public,private",
+			expected: map[string]string{"i-0001": "203.0.113.1", "i-0002": "10.0.0.2", "i-0004": "10.0.0.4"},
+			order:    []string{"i-0004", "i-0002", "i-0001"},
+		},
+		{
+			name:     "fallback to ssm",
+			hostType: "public,ssm",
+			expected: map[string]string{"i-0001": "203.0.113.1", "i-0002": "i-0002", "i-0004": "i-0004"},
+			order:    []string{"i-0004", "i-0002", "i-0001"},
+		},
+		{
+			name:     "network interface by device index",
+			hostType: "private",
+			netIf:    "1",
+			expected: map[string]string{"i-0001": "", "i-0002": "", "i-0004": "10.1.0.4"},
+			order:    []string{"i-0004", "i-0002", "i-0001"},
+		},
+		{
+			name:     "network interface by subnet",
+			hostType: "private",
+			netIf:    "subnet-mgmt",
+			expected: map[string]string{"i-0001": "", "i-0002": "", "i-0004": "10.1.0.4"},
+			order:    []string{"i-0004", "i-0002", "i-0001"},
+		},
+		{
+			name:     "network interface by instance tag",
+			hostType: "private",
+			netIf:    "tag:SshInterface",
+			expected: map[string]string{"i-0001": "", "i-0002": "", "i-0004": "10.1.0.4"},
+			order:    []string{"i-0004", "i-0002", "i-0001"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			choices := ConvertChoosableList(toCachedInstances(instances), nil, tt.hostType, tt.netIf)
+
+			actual := make(map[string]string)
+			order := make([]string, 0, len(choices))
+			for _, c := range choices {
+				e := c.(*ChoosableEC2)
+				if e.Unavailable != "" && e.Value() != "" {
+					t.Errorf("%s is unavailable but has value: %s", e.InstanceId, e.Value())
+				}
+				actual[e.InstanceId] = e.Value()
+				order = append(order, e.InstanceId)
+			}
+
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v but %v", tt.expected, actual)
+			}
+
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("expected order %v but %v", tt.order, order)
+			}
+		})
+	}
+}
+
+func TestConvertChoosableListSecondaryIPs(t *testing.T) {
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "appliance",
+			withNetworkInterface(1, "subnet-mgmt", "", "10.1.0.4", "10.1.0.5"),
+			withNetworkInterface(0, "subnet-aaaa", "203.0.113.4", "10.0.0.4")),
+	}
+
+	choices := ConvertChoosableList(toCachedInstances(instances), nil, "private", "")
+	e := choices[0].(*ChoosableEC2)
+
+	expected := []NetworkInterface{
+		{DeviceIndex: 0, SubnetId: "subnet-aaaa", PublicIP: "203.0.113.4", PrivateIPs: []string{"10.0.0.4"}},
+		{DeviceIndex: 1, SubnetId: "subnet-mgmt", PrivateIPs: []string{"10.1.0.4", "10.1.0.5"}},
+	}
+	if !reflect.DeepEqual(e.Interfaces, expected) {
+		t.Errorf("expected %v but %v", expected, e.Interfaces)
+	}
+}
+
+func TestLoadTargetHost(t *testing.T) {
+	tests := []struct {
+		name      string
+		instances []types.Instance
+		err       error
+		wantErr   bool
+		expected  []string
+	}{
+		{
+			name: "running instances",
+			instances: []types.Instance{
+				newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2")),
+				newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+			},
+			expected: []string{"203.0.113.1", "203.0.113.2"},
+		},
+		{
+			name: "no running instance",
+			instances: []types.Instance{
+				newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"), withState(types.InstanceStateNameStopped)),
+			},
+			wantErr: true,
+		},
+		{
+			name:    "AWS error",
+			err:     fmt.Errorf("UnauthorizedOperation"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			f := &fakeEC2{Instances: tt.instances, Err: tt.err}
+			h := newFakeEC2Handler(dir, f)
+
+			choices, err := h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", "ap-northeast-1", false)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error but nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			actual := make([]string, 0, len(choices))
+			for _, c := range choices {
+				actual = append(actual, c.Value())
+			}
+
+			if !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v but %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLoadTargetHostCache(t *testing.T) {
+	dir := t.TempDir()
+
+	f := &fakeEC2{
+		Instances: []types.Instance{
+			newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+		},
+	}
+	h := newFakeEC2Handler(dir, f)
+
+	steps := []struct {
+		name      string
+		region    string
+		reload    bool
+		instances []types.Instance
+		expected  []string
+		calls     int
+	}{
+		{
+			name:     "no cache, connect to AWS",
+			region:   "ap-northeast-1",
+			expected: []string{"203.0.113.1"},
+			calls:    1,
+		},
+		{
+			name:      "load from cache",
+			region:    "ap-northeast-1",
+			instances: []types.Instance{newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2"))},
+			expected:  []string{"203.0.113.1"},
+			calls:     1,
+		},
+		{
+			name:     "reload",
+			region:   "ap-northeast-1",
+			reload:   true,
+			expected: []string{"203.0.113.2"},
+			calls:    2,
+		},
+		{
+			name:     "cache is per region",
+			region:   "us-east-1",
+			expected: []string{"203.0.113.2"},
+			calls:    3,
+		},
+	}
+
+	for _, s := range steps {
+		if s.instances != nil {
+			f.Instances = s.instances
+		}
+
+		choices, err := h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", s.region, s.reload)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", s.name, err)
+		}
+
+		actual := make([]string, 0, len(choices))
+		for _, c := range choices {
+			actual = append(actual, c.Value())
+		}
+
+		if !reflect.DeepEqual(actual, s.expected) {
+			t.Errorf("%s: expected %v but %v", s.name, s.expected, actual)
+		}
+
+		if f.DescribeInstancesCount != s.calls {
+			t.Errorf("%s: expected DescribeInstances %d times but %d", s.name, s.calls, f.DescribeInstancesCount)
+		}
+	}
+}
+
+func TestLoadTargetHostOldCache(t *testing.T) {
+	dir := t.TempDir()
+
+	f := &fakeEC2{
+		Instances: []types.Instance{
+			newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+		},
+	}
+	h := newFakeEC2Handler(dir, f)
+
+	// cache before versioning
+	old := Instances{Instances: toCachedInstances([]types.Instance{newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2"))})}
+	if err := SaveCache(cacheFilePath(dir, "ap-northeast-1"), &old); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.LoadCache("ap-northeast-1"); err != ErrCacheVersion {
+		t.Errorf("expected ErrCacheVersion but %v", err)
+	}
+
+	choices, err := h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", "ap-northeast-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(choices) != 1 || choices[0].Value() != "203.0.113.1" {
+		t.Errorf("expected old cache is reloaded but %v", choices)
+	}
+
+	is, err := h.LoadCache("ap-northeast-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if is.Version != INSTANCES_CACHE_VERSION {
+		t.Errorf("expected cache version %d but %d", INSTANCES_CACHE_VERSION, is.Version)
+	}
+}
+
+func TestLoadTargetHostCacheTTL(t *testing.T) {
+	dir := t.TempDir()
+
+	f := &fakeEC2{
+		Instances: []types.Instance{
+			newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+		},
+	}
+	h := newFakeEC2Handler(dir, f)
+	h.CacheTTL = time.Hour
+
+	cached := Instances{Version: INSTANCES_CACHE_VERSION, Instances: toCachedInstances([]types.Instance{newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2"))})}
+	path := cacheFilePath(dir, "ap-northeast-1")
+	if err := SaveCache(path, &cached); err != nil {
+		t.Fatal(err)
+	}
+
+	choices, err := h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", "ap-northeast-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(choices) != 1 || choices[0].Value() != "203.0.113.2" {
+		t.Errorf("expected cache is used in TTL but %v", choices)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	choices, err = h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", "ap-northeast-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(choices) != 1 || choices[0].Value() != "203.0.113.1" {
+		t.Errorf("expected expired cache is reloaded but %v", choices)
+	}
+}
+
+func TestRefreshCacheConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	f := &fakeEC2{
+		Instances: []types.Instance{newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"))},
+		Wait:      make(chan struct{}),
+	}
+
+	// each refresh is other rnssh process. (own lock file descriptor)
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	refresh := func() {
+		defer wg.Done()
+		is, err := newFakeEC2Handler(dir, f).RefreshCache("ap-northeast-1")
+		if err == nil && len(is.Instances) != 1 {
+			err = fmt.Errorf("expected 1 instance but %d", len(is.Instances))
+		}
+		errs <- err
+	}
+
+	wg.Add(1)
+	go refresh()
+
+	// the first refresh is calling AWS with lock.
+	for f.describeInstancesCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg.Add(1)
+	go refresh()
+
+	// the second refresh is waiting lock.
+	time.Sleep(50 * time.Millisecond)
+	close(f.Wait)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if c := f.describeInstancesCount(); c != 1 {
+		t.Errorf("expected the second refresh uses the first result but DescribeInstances %d times", c)
+	}
+
+	// no temp file is left.
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	if len(files) != 0 {
+		t.Errorf("expected no temp file but %v", files)
+	}
+
+	// sequential refresh connects to AWS.
+	if _, err := newFakeEC2Handler(dir, f).RefreshCache("ap-northeast-1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if c := f.describeInstancesCount(); c != 2 {
+		t.Errorf("expected DescribeInstances 2 times but %d", c)
+	}
+}
+
+func TestSaveCacheKeepsOldOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := cacheFilePath(dir, "ap-northeast-1")
+	is := &Instances{Version: INSTANCES_CACHE_VERSION, Instances: toCachedInstances([]types.Instance{newFakeInstance("i-0001", "web1")})}
+	if err := SaveCache(path, is); err != nil {
+		t.Fatal(err)
+	}
+
+	// can not rename to directory.
+	if err := SaveCache(dir, is); err == nil {
+		t.Errorf("expected error but nil")
+	}
+
+	if _, err := newFakeEC2Handler(dir, &fakeEC2{}).LoadCache("ap-northeast-1"); err != nil {
+		t.Errorf("expected cache is readable but %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(dir), "*.tmp*"))
+	if len(files) != 0 {
+		t.Errorf("expected temp file is removed but %v", files)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected cache exists but %v", err)
+	}
+}
+
+func TestLoadTargetHostImageNames(t *testing.T) {
+	dir := t.TempDir()
+
+	i := newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1"))
+	i.ImageId = aws.String("ami-0001")
+
+	// deregistered AMI does not break names of others.
+	old := newFakeInstance("i-0002", "old1", withPublicIP("203.0.113.2"))
+	old.ImageId = aws.String("ami-deregistered")
+	f := &fakeEC2{
+		Instances: []types.Instance{i, old},
+		Images:    []types.Image{{ImageId: aws.String("ami-0001"), Name: aws.String("ubuntu/images/hvm-ssd/ubuntu-jammy-22.04")}},
+	}
+	h := newFakeEC2Handler(dir, f)
+
+	choices, err := h.LoadTargetHost(HOST_TYPE_PUBLIC_IP, "", "ap-northeast-1", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	users := make(map[string]string)
+	for _, c := range choices {
+		e := c.(*ChoosableEC2)
+		users[e.InstanceId] = DetectSshUser(e, "", nil)
+	}
+
+	if users["i-0001"] != "ubuntu" || users["i-0002"] != "" {
+		t.Errorf("expected ubuntu for i-0001 and empty for i-0002 but %v", users)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ENV_RNSSH_PROFILE = "RNSSH_PROFILE"
+
+	// env values override config file (for CI containers). ex: RNSSH_ENV_OVERRIDE=1
+	ENV_RNSSH_ENV_OVERRIDE = "RNSSH_ENV_OVERRIDE"
+)
+
+// config key -> environment variable names. the first one that is set is used.
+var configEnvNames = map[string][]string{
+	"aws_region":                      {"RNSSH_AWS_REGION", ENV_AWS_REGION},
+	"host_type":                       {ENV_RNSSH_HOST_TYPE},
+	"ssh_user":                        {"RNSSH_SSH_USER"},
+	"ssh_identity_file":               {"RNSSH_SSH_IDENTITY_FILE"},
+	"ssh_port":                        {"RNSSH_SSH_PORT"},
+	"ssh_strict_host_key_checking_no": {"RNSSH_SSH_STRICT_HOST_KEY_CHECKING_NO"},
+	"use_ssh_config":                  {"RNSSH_USE_SSH_CONFIG"},
+	"selector":                        {"RNSSH_SELECTOR"},
+	"selector_options":                {"RNSSH_SELECTOR_OPTIONS"},
+	"ec2_endpoint":                    {"RNSSH_EC2_ENDPOINT"},
+	"network_interface":               {"RNSSH_NETWORK_INTERFACE"},
+	"ssh_identity_file_template":      {"RNSSH_SSH_IDENTITY_FILE_TEMPLATE"},
+	"ssh_identity_file_by_key_name":   {"RNSSH_SSH_IDENTITY_FILE_BY_KEY_NAME"},
+	"rdp_command":                     {"RNSSH_RDP_COMMAND"},
+	"ssh_user_tag":                    {"RNSSH_SSH_USER_TAG"},
+	"ssh_user_by_ami":                 {"RNSSH_SSH_USER_BY_AMI"},
+	"cache_ttl":                       {"RNSSH_CACHE_TTL"},
+	"instance_known_hosts":            {"RNSSH_INSTANCE_KNOWN_HOSTS"},
+	"verify_host_key":                 {"RNSSH_VERIFY_HOST_KEY"},
+	"ssh_cert_sign_command":           {"RNSSH_SSH_CERT_SIGN_COMMAND"},
+	"ssh_cert_signer_url":             {"RNSSH_SSH_CERT_SIGNER_URL"},
+	"ssh_cert_public_key":             {"RNSSH_SSH_CERT_PUBLIC_KEY"},
+	"ssh_agent":                       {"RNSSH_SSH_AGENT"},
+	"ssh_agent_lifetime":              {"RNSSH_SSH_AGENT_LIFETIME"},
+	"ssh_agent_socket":                {"RNSSH_SSH_AGENT_SOCKET"},
+	"session_recording":               {"RNSSH_SESSION_RECORDING"},
+}
+
+// ConfigEnvNames returns all environment variable names that rnssh reads. (sorted)
+func ConfigEnvNames() []string {
+	names := []string{ENV_RNSSH_PROFILE, ENV_RNSSH_ENV_OVERRIDE}
+	for _, ns := range configEnvNames {
+		names = append(names, ns...)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// envConfig reads config from environment variables.
+// it returns config, config key -> environment variable name that is set.
+func envConfig() (*RnsshConfig, map[string]string, error) {
+	conf := &RnsshConfig{}
+	names := make(map[string]string)
+	for _, key := range ConfigKeys() {
+		for _, name := range configEnvNames[key] {
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+
+			if err := setEnvConfigValue(conf, key, value); err != nil {
+				return nil, nil, fmt.Errorf("invalid environment variable %s: %s", name, err.Error())
+			}
+			names[key] = name
+			break
+		}
+	}
+
+	for _, e := range conf.ValidateAll() {
+		if name, ok := names[e.Key]; ok {
+			return nil, nil, fmt.Errorf("invalid environment variable %s: %s", name, e.Err.Error())
+		}
+	}
+
+	return conf, names, nil
+}
+
+// setEnvConfigValue sets env value. map value is comma separated name=value. (ex: ubuntu=ubuntu,debian=admin)
+func setEnvConfigValue(c *RnsshConfig, key, value string) error {
+	v, _ := configValue(c, key)
+	if v.Kind() != reflect.Map {
+		return setConfigValue(c, key, value)
+	}
+
+	for _, kv := range strings.Split(value, ",") {
+		nv := strings.SplitN(kv, "=", 2)
+		if len(nv) != 2 || strings.TrimSpace(nv[0]) == "" {
+			return fmt.Errorf("invalid format: %s. please specify name=value,name=value", value)
+		}
+
+		if err := setConfigValue(c, key+"."+strings.TrimSpace(nv[0]), strings.TrimSpace(nv[1])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// envOverride returns true if env values override config file.
+func envOverride() bool {
+	b, _ := strconv.ParseBool(os.Getenv(ENV_RNSSH_ENV_OVERRIDE))
+	return b
+}
+
+// profileName returns -profile option or RNSSH_PROFILE.
+func profileName(opt *CommandOption) string {
+	if opt.Profile != "" {
+		return opt.Profile
+	}
+
+	return os.Getenv(ENV_RNSSH_PROFILE)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigEnvNames(t *testing.T) {
+	for _, key := range ConfigKeys() {
+		if len(configEnvNames[key]) == 0 {
+			t.Errorf("config key %s has no environment variable", key)
+		}
+	}
+}
+
+func TestMergeConfigEnv(t *testing.T) {
+	cases := []struct {
+		name     string
+		env      map[string]string
+		conf     RnsshConfig
+		opt      CommandOption
+		expected func(rOpt *RnsshOption) bool
+		source   map[string]string
+	}{
+		{
+			name: "env is prior to default",
+			env:  map[string]string{"RNSSH_SSH_USER": "deploy", "RNSSH_SSH_PORT": "2222", "RNSSH_CACHE_TTL": "30m"},
+			expected: func(r *RnsshOption) bool {
+				return r.SshUser == "deploy" && r.Port == 2222 && r.CacheTTL == 30*time.Minute && r.Selector == SELECTOR_PECO
+			},
+			source: map[string]string{"ssh_user": "env:RNSSH_SSH_USER", "selector": SOURCE_DEFAULT},
+		},
+		{
+			name: "config is prior to env",
+			env:  map[string]string{"RNSSH_SSH_USER": "deploy", ENV_AWS_REGION: "us-east-1"},
+			conf: RnsshConfig{SshUser: "ec2-user", AWSRegion: "us-west-2"},
+			expected: func(r *RnsshOption) bool {
+				return r.SshUser == "ec2-user" && r.Region == "us-west-2"
+			},
+			source: map[string]string{"ssh_user": SOURCE_CONFIG, "aws_region": SOURCE_CONFIG},
+		},
+		{
+			name: "flag is prior to all",
+			env:  map[string]string{"RNSSH_SSH_USER": "deploy", ENV_RNSSH_ENV_OVERRIDE: "1"},
+			conf: RnsshConfig{SshUser: "ec2-user"},
+			opt:  CommandOption{SshUser: "admin", StrictHostKeyCheckingNo: -1},
+			expected: func(r *RnsshOption) bool {
+				return r.SshUser == "admin"
+			},
+			source: map[string]string{"ssh_user": SOURCE_FLAG},
+		},
+		{
+			name: "env overrides config",
+			env: map[string]string{
+				ENV_RNSSH_ENV_OVERRIDE:  "true",
+				"RNSSH_SSH_USER":        "deploy",
+				"RNSSH_USE_SSH_CONFIG":  "false",
+				"RNSSH_AWS_REGION":      "eu-west-1",
+				ENV_AWS_REGION:          "us-east-1",
+				"RNSSH_SSH_USER_BY_AMI": "ubuntu=ubuntu, debian=admin",
+			},
+			conf: RnsshConfig{SshUser: "ec2-user", UseSshConfig: true, AWSRegion: "us-west-2"},
+			expected: func(r *RnsshOption) bool {
+				return r.SshUser == "deploy" && !r.UseSshConfig && r.Region == "eu-west-1" &&
+					reflect.DeepEqual(r.SshUserByAMI, map[string]string{"ubuntu": "ubuntu", "debian": "admin"})
+			},
+			source: map[string]string{"ssh_user": "env:RNSSH_SSH_USER", "use_ssh_config": "env:RNSSH_USE_SSH_CONFIG", "aws_region": "env:RNSSH_AWS_REGION"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, name := range ConfigEnvNames() {
+				t.Setenv(name, c.env[name])
+			}
+
+			if c.opt.StrictHostKeyCheckingNo == 0 {
+				c.opt.StrictHostKeyCheckingNo = -1
+			}
+
+			rOpt, err := mergeConfig(c.opt, configLayer{Source: SOURCE_CONFIG, Config: &c.conf})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !c.expected(rOpt) {
+				t.Errorf("unexpected option: %+v", rOpt)
+			}
+
+			for key, source := range c.source {
+				if rOpt.Sources[key] != source {
+					t.Errorf("expected %s source is %s but %s", key, source, rOpt.Sources[key])
+				}
+			}
+		})
+	}
+}
+
+func TestMergeConfigInvalidEnv(t *testing.T) {
+	cases := map[string]string{
+		"RNSSH_SSH_PORT":        "x",
+		"RNSSH_USE_SSH_CONFIG":  "maybe",
+		"RNSSH_HOST_TYPE":       "unknown",
+		"RNSSH_CACHE_TTL":       "1day",
+		"RNSSH_SSH_USER_BY_AMI": "ubuntu",
+	}
+
+	for name, value := range cases {
+		t.Run(name, func(t *testing.T) {
+			for _, n := range ConfigEnvNames() {
+				t.Setenv(n, "")
+			}
+			t.Setenv(name, value)
+
+			_, err := mergeConfig(CommandOption{StrictHostKeyCheckingNo: -1})
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("expected error with %s but %v", name, err)
+			}
+		})
+	}
+}
+
+func TestAppRunProfileEnv(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+
+[[profiles]]
+  profile_name = "staging"
+  aws_region = "eu-west-1"
+`
+	a := newTestApp(t, "", config)
+	t.Setenv(ENV_RNSSH_PROFILE, "staging")
+
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "profile: staging") || !strings.Contains(a.Out.String(), "eu-west-1") {
+		t.Errorf("expected staging profile by %s but %q", ENV_RNSSH_PROFILE, a.Out.String())
+	}
+}
+
+func TestAppRunConfigZeroOverEnv(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+  use_ssh_config = false
+
+[[profiles]]
+  profile_name = "prod"
+  ssh_port = 0
+`
+	a := newTestApp(t, "", config)
+	t.Setenv("RNSSH_USE_SSH_CONFIG", "true")
+	t.Setenv("RNSSH_SSH_PORT", "2222")
+
+	if code := a.Run([]string{"config", "show", "-profile", "prod"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	// option > config > env. zero value in config also overrides env.
+	source := regexp.QuoteMeta(SOURCE_CONFIG + ":" + filepath.Join(a.RnsshDir, "config"))
+	for _, e := range []string{`use_ssh_config\s+false\s+` + source, `ssh_port\s+0\s+` + source} {
+		if !regexp.MustCompile(`(?m)^` + e + `$`).MatchString(a.Out.String()) {
+			t.Errorf("expected %s but %q", e, a.Out.String())
+		}
+	}
+
+	// RNSSH_ENV_OVERRIDE makes env prior to config.
+	a.Out.Reset()
+	t.Setenv(ENV_RNSSH_ENV_OVERRIDE, "1")
+	if code := a.Run([]string{"config", "show", "-profile", "prod"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !regexp.MustCompile(`(?m)^use_ssh_config\s+true\s+env:RNSSH_USE_SSH_CONFIG$`).MatchString(a.Out.String()) {
+		t.Errorf("expected env is prior but %q", a.Out.String())
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+
+	"github.com/reiki4040/peco"
+)
+
+// cloud-init prints host public keys to console between these lines.
+const (
+	CONSOLE_HOST_KEYS_BEGIN = "-----BEGIN SSH HOST KEY KEYS-----"
+	CONSOLE_HOST_KEYS_END   = "-----END SSH HOST KEY KEYS-----"
+)
+
+// HostKey is public key of ssh server.
+type HostKey struct {
+	Type string
+	Key  string
+}
+
+func (k HostKey) String() string {
+	return k.Type + " " + k.Key
+}
+
+func (k HostKey) blob() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(k.Key)
+}
+
+// Fingerprint returns SHA256 fingerprint like ssh-keygen -l.
+func (k HostKey) Fingerprint() string {
+	blob, _ := k.blob()
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// ParseHostKey parses "type base64 [comment]". key type must be the same as in the key blob.
+func ParseHostKey(fields []string) (HostKey, error) {
+	if len(fields) < 2 {
+		return HostKey{}, fmt.Errorf("invalid host key: %s", strings.Join(fields, " "))
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return HostKey{}, fmt.Errorf("invalid host key %s: %s", fields[0], err.Error())
+	}
+
+	// key blob starts with length and key type.
+	if len(blob) < 4 {
+		return HostKey{}, fmt.Errorf("invalid host key %s: too short", fields[0])
+	}
+	l := binary.BigEndian.Uint32(blob)
+	if uint64(len(blob)) < 4+uint64(l) || string(blob[4:4+l]) != fields[0] {
+		return HostKey{}, fmt.Errorf("invalid host key %s: key type is not matched", fields[0])
+	}
+
+	return HostKey{Type: fields[0], Key: fields[1]}, nil
+}
+
+// ParseConsoleHostKeys returns host keys in EC2 console output.
+func ParseConsoleHostKeys(output string) ([]HostKey, error) {
+	keys := make([]HostKey, 0)
+	inKeys := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasSuffix(line, CONSOLE_HOST_KEYS_BEGIN):
+			inKeys = true
+			continue
+		case strings.HasSuffix(line, CONSOLE_HOST_KEYS_END):
+			inKeys = false
+			continue
+		}
+
+		if !inKeys {
+			continue
+		}
+
+		// some console has prefix. (ex: "ec2: ")
+		fields := strings.Fields(line)
+		for i, f := range fields {
+			if strings.HasPrefix(f, "ssh-") || strings.HasPrefix(f, "ecdsa-") || strings.HasPrefix(f, "sk-") {
+				fields = fields[i:]
+				break
+			}
+		}
+
+		k, err := ParseHostKey(fields)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("ssh host keys are not found in console output")
+	}
+
+	return keys, nil
+}
+
+// LoadKnownHostKeys returns host keys of the host in known_hosts file. no file is empty.
+func LoadKnownHostKeys(path, host string) ([]HostKey, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys := make([]HostKey, 0)
+	hosts := map[string]bool{host: true}
+	for _, line := range strings.Split(string(content), "\n") {
+		// @cert-authority and @revoked are not host key.
+		if strings.HasPrefix(line, "@") || !knownHostsLineMatches(line, hosts) {
+			continue
+		}
+
+		k, err := ParseHostKey(strings.Fields(line)[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+// AddKnownHostKeys appends host keys of the host to known_hosts file.
+func AddKnownHostKeys(path, host string, keys []HostKey) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+
+	return writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(w, "%s %s\n", host, k.String()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// GetConsoleOutput returns console output (decoded) of the instance.
+func (r *EC2Handler) GetConsoleOutput(region, instanceId string) (string, error) {
+	cli, err := r.NewClient(region)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := cli.GetConsoleOutput(context.TODO(), &ec2.GetConsoleOutputInput{InstanceId: aws.String(instanceId)})
+	if err != nil {
+		return "", err
+	}
+
+	output, err := base64.StdEncoding.DecodeString(convertNilString(resp.Output))
+	if err != nil {
+		return "", err
+	}
+
+	return string(output), nil
+}
+
+// verifyHostKey seeds rnssh known_hosts with host keys in EC2 console output before first connect.
+// ssh verifies the host key with StrictHostKeyChecking=yes, so it fails if the server has other key.
+// with showCommand, it only shows that host keys will be seeded. (no API call and no file change)
+func (a *App) verifyHostKey(rOpt *RnsshOption, handler *EC2Handler, targetHost peco.Choosable, showCommand bool) error {
+	e, ok := targetHost.(*ChoosableEC2)
+	if !ok || !rOpt.VerifyHostKey {
+		return nil
+	}
+
+	known, err := LoadKnownHostKeys(rOpt.KnownHostsFile, e.InstanceId)
+	if err != nil {
+		return fmt.Errorf("can not verify host key: %s", err.Error())
+	}
+
+	// already verified at first connect.
+	if len(known) > 0 {
+		return nil
+	}
+
+	if showCommand {
+		fmt.Fprintf(a.Stdout, "# host key of %s will be added to %s from console output\n", e.InstanceId, rOpt.KnownHostsFile)
+		return nil
+	}
+
+	output, err := handler.GetConsoleOutput(rOpt.Region, e.InstanceId)
+	if err != nil {
+		return fmt.Errorf("can not verify host key of %s: can not get console output: %s", e.InstanceId, err.Error())
+	}
+
+	keys, err := ParseConsoleHostKeys(output)
+	if err != nil {
+		return fmt.Errorf("can not verify host key of %s: %s. (console output is available a few minutes after launch)", e.InstanceId, err.Error())
+	}
+
+	if err := AddKnownHostKeys(rOpt.KnownHostsFile, e.InstanceId, keys); err != nil {
+		return fmt.Errorf("can not save host key: %s", err.Error())
+	}
+
+	for _, k := range keys {
+		fmt.Fprintf(a.Stderr, "added host key of %s from console output: %s %s\n", e.InstanceId, k.Type, k.Fingerprint())
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testHostKey returns host key that has key type in the blob.
+func testHostKey(keyType, body string) HostKey {
+	blob := make([]byte, 4, 4+len(keyType)+len(body))
+	binary.BigEndian.PutUint32(blob, uint32(len(keyType)))
+	blob = append(append(blob, keyType...), body...)
+	return HostKey{Type: keyType, Key: base64.StdEncoding.EncodeToString(blob)}
+}
+
+func testConsoleOutput(keys ...HostKey) string {
+	lines := []string{"[   10.1] cloud-init[1234]: Cloud-init v. 23.1 running", "ec2: " + CONSOLE_HOST_KEYS_BEGIN}
+	for _, k := range keys {
+		lines = append(lines, k.String()+" root@ip-10-0-0-1")
+	}
+	lines = append(lines, "ec2: "+CONSOLE_HOST_KEYS_END, "login: ")
+	return strings.Join(lines, "\r\n")
+}
+
+func TestParseConsoleHostKeys(t *testing.T) {
+	ed := testHostKey("ssh-ed25519", "ed25519-body")
+	ecdsa := testHostKey("ecdsa-sha2-nistp256", "ecdsa-body")
+
+	keys, err := ParseConsoleHostKeys(testConsoleOutput(ecdsa, ed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(keys, []HostKey{ecdsa, ed}) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	if _, err := ParseConsoleHostKeys("[   10.1] booting\n"); err == nil {
+		t.Errorf("expected error of no host keys")
+	}
+
+	// key type in the blob is different.
+	fake := HostKey{Type: "ssh-ed25519", Key: testHostKey("ssh-rsa", "rsa-body").Key}
+	if _, err := ParseConsoleHostKeys(testConsoleOutput(fake)); err == nil {
+		t.Errorf("expected error of invalid host key")
+	}
+}
+
+func TestAppRunVerifyHostKey(t *testing.T) {
+	ed := testHostKey("ssh-ed25519", "ed25519-body")
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n"
+	a := newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+	path := knownHostsPath(a.RnsshDir)
+
+	// show command does not get console output and does not save host key.
+	if code := a.Run([]string{"-verify-host-key", "-s"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "# host key of i-0001 will be added to "+path+" from console output\n") {
+		t.Errorf("expected show message but %q", a.Out.String())
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected known_hosts is not created but %v", err)
+	}
+
+	a.EC2.ConsoleOutput = map[string]string{"i-0001": testConsoleOutput(ed)}
+	if code := a.Run([]string{"-verify-host-key"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	expected := []string{"-oHostKeyAlias=i-0001", "-oUserKnownHostsFile=" + path, "-oHashKnownHosts=no", "-oStrictHostKeyChecking=yes", "203.0.113.1"}
+	if !reflect.DeepEqual(a.Recorder.Args, expected) {
+		t.Errorf("expected ssh args %v but %v", expected, a.Recorder.Args)
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "i-0001 "+ed.String()+"\n" {
+		t.Errorf("unexpected known_hosts: %q", string(b))
+	}
+
+	if !strings.Contains(a.Out.String(), "added host key of i-0001 from console output: ssh-ed25519 "+ed.Fingerprint()) {
+		t.Errorf("expected fingerprint message but %q", a.Out.String())
+	}
+
+	// known host key is not verified again. (ssh checks it)
+	a.EC2.ConsoleOutput = nil
+	if code := a.Run([]string{"-verify-host-key"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+}
+
+func TestAppRunVerifyHostKeyFailClosed(t *testing.T) {
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n  verify_host_key = true\n"
+	a := newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+	a.EC2.ConsoleOutput = map[string]string{"i-0001": "[   10.1] booting\n"}
+
+	if code := a.Run([]string{}); code != 1 {
+		t.Fatalf("expected exit code 1 but %d", code)
+	}
+
+	if a.Recorder.Name != "" {
+		t.Errorf("expected ssh is not run but %s %v", a.Recorder.Name, a.Recorder.Args)
+	}
+
+	if !strings.Contains(a.Out.String(), "can not verify host key of i-0001: ssh host keys are not found") {
+		t.Errorf("unexpected output: %q", a.Out.String())
+	}
+
+	if _, err := os.Stat(knownHostsPath(a.RnsshDir)); !os.IsNotExist(err) {
+		t.Errorf("expected known_hosts is not created but %v", err)
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-strict-host-key-checking-no", "1"}); code != 1 {
+		t.Fatalf("expected exit code 1 but %d", code)
+	}
+
+	if !strings.Contains(a.Out.String(), "can not verify host key with strict host key checking no") {
+		t.Errorf("unexpected output: %q", a.Out.String())
+	}
+}
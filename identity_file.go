@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// values for ssh_identity_file_template. ex: ~/.ssh/{{.KeyName}}.pem
+type IdentityFileTemplateValues struct {
+	KeyName    string
+	InstanceId string
+	Name       string
+}
+
+func IdentityFileTemplateCheck(t string) error {
+	if t == "" {
+		return nil
+	}
+
+	if _, err := parseIdentityFileTemplate(t); err != nil {
+		return fmt.Errorf("invalid IdentityFileTemplate value: %s", err.Error())
+	}
+
+	return nil
+}
+
+func parseIdentityFileTemplate(t string) (*template.Template, error) {
+	return template.New("identity_file").Option("missingkey=error").Parse(t)
+}
+
+// ResolveIdentityFile returns identity file path for the instance.
+// priority [high] key name mapping > template > default identity file [low]
+// the instances that has no key pair use default identity file.
+func ResolveIdentityFile(e *ChoosableEC2, defaultFile, fileTemplate string, fileByKeyName map[string]string) (string, error) {
+	if e.KeyName == "" {
+		return defaultFile, nil
+	}
+
+	path := ""
+	if p, ok := fileByKeyName[e.KeyName]; ok {
+		path = p
+	} else if fileTemplate != "" {
+		t, err := parseIdentityFileTemplate(fileTemplate)
+		if err != nil {
+			return "", err
+		}
+
+		var b bytes.Buffer
+		v := IdentityFileTemplateValues{
+			KeyName:    e.KeyName,
+			InstanceId: e.InstanceId,
+			Name:       e.Name,
+		}
+		if err := t.Execute(&b, v); err != nil {
+			return "", err
+		}
+
+		path = b.String()
+	} else {
+		return defaultFile, nil
+	}
+
+	if err := IdentityFileCheck(path); err != nil {
+		return "", fmt.Errorf("identity file for key pair %s (%s) is missing: %s. please check ssh_identity_file_template / ssh_identity_file_by_key_name config or specify -i option", e.KeyName, e.InstanceId, err.Error())
+	}
+
+	return path, nil
+}
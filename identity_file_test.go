@@ -0,0 +1,118 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveIdentityFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"default", "mapped.pem", "web.pem", "i-1234-web.pem"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("key"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defaultFile := filepath.Join(dir, "default")
+	byKeyName := map[string]string{
+		"mapped": filepath.Join(dir, "mapped.pem"),
+	}
+
+	cases := []struct {
+		name         string
+		e            *ChoosableEC2
+		fileTemplate string
+		byKeyName    map[string]string
+		expected     string
+		errContains  string
+	}{
+		{
+			name:         "key name mapping is prior to template",
+			e:            &ChoosableEC2{InstanceId: "i-1234", KeyName: "mapped"},
+			fileTemplate: filepath.Join(dir, "{{.KeyName}}-missing.pem"),
+			byKeyName:    byKeyName,
+			expected:     filepath.Join(dir, "mapped.pem"),
+		},
+		{
+			name:         "template is prior to default",
+			e:            &ChoosableEC2{InstanceId: "i-1234", KeyName: "web"},
+			fileTemplate: filepath.Join(dir, "{{.KeyName}}.pem"),
+			byKeyName:    byKeyName,
+			expected:     filepath.Join(dir, "web.pem"),
+		},
+		{
+			name:         "template with instance values",
+			e:            &ChoosableEC2{InstanceId: "i-1234", Name: "web", KeyName: "web"},
+			fileTemplate: filepath.Join(dir, "{{.InstanceId}}-{{.Name}}.pem"),
+			expected:     filepath.Join(dir, "i-1234-web.pem"),
+		},
+		{
+			name:         "template renders missing file",
+			e:            &ChoosableEC2{InstanceId: "i-1234", KeyName: "db"},
+			fileTemplate: filepath.Join(dir, "{{.KeyName}}.pem"),
+			byKeyName:    byKeyName,
+			errContains:  "is missing",
+		},
+		{
+			name:         "no key pair uses default",
+			e:            &ChoosableEC2{InstanceId: "i-1234"},
+			fileTemplate: filepath.Join(dir, "{{.KeyName}}.pem"),
+			byKeyName:    byKeyName,
+			expected:     defaultFile,
+		},
+		{
+			name:      "no mapping and no template uses default",
+			e:         &ChoosableEC2{InstanceId: "i-1234", KeyName: "web"},
+			byKeyName: byKeyName,
+			expected:  defaultFile,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path, err := ResolveIdentityFile(c.e, defaultFile, c.fileTemplate, c.byKeyName)
+			if c.errContains != "" {
+				if err == nil || !strings.Contains(err.Error(), c.errContains) {
+					t.Fatalf("expected error contains %q but %v", c.errContains, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if path != c.expected {
+				t.Errorf("expected %q but %q", c.expected, path)
+			}
+		})
+	}
+}
+
+func TestIdentityFileTemplateCheck(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		valid    bool
+	}{
+		{name: "empty", template: "", valid: true},
+		{name: "valid", template: "~/.ssh/{{.KeyName}}.pem", valid: true},
+		{name: "unclosed action", template: "~/.ssh/{{.KeyName.pem", valid: false},
+		{name: "unknown function", template: "~/.ssh/{{unknown .KeyName}}.pem", valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := IdentityFileTemplateCheck(c.template)
+			if c.valid && err != nil {
+				t.Errorf("expected valid but %v", err)
+			}
+
+			if !c.valid && err == nil {
+				t.Errorf("expected error but nil")
+			}
+		})
+	}
+}
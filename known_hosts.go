@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rnssh known_hosts. host keys are saved with instance id (HostKeyAlias) instead of IP address,
+// so reused IP address does not cause "REMOTE HOST IDENTIFICATION HAS CHANGED".
+const KNOWN_HOSTS_FILE_NAME = "known_hosts"
+
+func knownHostsPath(rnsshDir string) string {
+	return filepath.Join(rnsshDir, KNOWN_HOSTS_FILE_NAME)
+}
+
+// knownHostsOptions returns ssh options that verify host key with instance id.
+func knownHostsOptions(knownHostsFile, instanceId string) []string {
+	return []string{
+		"HostKeyAlias=" + instanceId,
+		"UserKnownHostsFile=" + knownHostsFile,
+		// instance id is plain for cleanup. (also hashed entry is cleaned up)
+		"HashKnownHosts=no",
+	}
+}
+
+// RemoveKnownHosts removes entries of the hosts (instance ids) from known_hosts file.
+// it returns the number of removed entries. no file is 0.
+func RemoveKnownHosts(path string, hosts []string) (int, error) {
+	if len(hosts) == 0 {
+		return 0, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	targets := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		targets[h] = true
+	}
+
+	kept := &bytes.Buffer{}
+	removed := 0
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for s.Scan() {
+		line := s.Text()
+		if knownHostsLineMatches(line, targets) {
+			removed++
+			continue
+		}
+		kept.WriteString(line + "\n")
+	}
+
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(kept.Bytes())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return removed, nil
+}
+
+// knownHostsLineMatches returns true if the host patterns of the line contain one of hosts.
+func knownHostsLineMatches(line string, hosts map[string]bool) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return false
+	}
+
+	// @cert-authority, @revoked
+	if strings.HasPrefix(fields[0], "@") {
+		if len(fields) < 2 {
+			return false
+		}
+		fields = fields[1:]
+	}
+
+	for _, pattern := range strings.Split(fields[0], ",") {
+		if strings.HasPrefix(pattern, "|1|") {
+			for h := range hosts {
+				if hashedHostMatches(pattern, h) {
+					return true
+				}
+			}
+			continue
+		}
+
+		// [host]:port
+		if strings.HasPrefix(pattern, "[") {
+			if i := strings.Index(pattern, "]"); i > 0 {
+				pattern = pattern[1:i]
+			}
+		}
+
+		if hosts[pattern] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashedHostMatches checks hashed known_hosts entry. (|1|base64(salt)|base64(hmac-sha1(salt, host)))
+func hashedHostMatches(hashed, host string) bool {
+	parts := strings.Split(hashed, "|")
+	if len(parts) != 4 {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), sum)
+}
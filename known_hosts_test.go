@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+func hashKnownHost(salt []byte, host string) string {
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestRemoveKnownHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), KNOWN_HOSTS_FILE_NAME)
+	content := "# comment i-0001\n" +
+		"i-0001 ssh-ed25519 AAAA1\n" +
+		"[i-0001]:2222 ssh-ed25519 AAAA2\n" +
+		hashKnownHost([]byte("salt1234salt1234salt"), "i-0001") + " ssh-ed25519 AAAA3\n" +
+		"@cert-authority i-0001 ssh-ed25519 AAAA4\n" +
+		"i-0002 ssh-ed25519 AAAA5\n" +
+		hashKnownHost([]byte("salt1234salt1234salt"), "i-0002") + " ssh-ed25519 AAAA6\n" +
+		"i-00011 ssh-ed25519 AAAA7\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := RemoveKnownHosts(path, []string{"i-0001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Errorf("expected 4 entries are removed but %d", n)
+	}
+
+	expected := "# comment i-0001\n" +
+		"i-0002 ssh-ed25519 AAAA5\n" +
+		hashKnownHost([]byte("salt1234salt1234salt"), "i-0002") + " ssh-ed25519 AAAA6\n" +
+		"i-00011 ssh-ed25519 AAAA7\n"
+	if b, _ := os.ReadFile(path); string(b) != expected {
+		t.Errorf("expected %q but %q", expected, string(b))
+	}
+
+	if n, err := RemoveKnownHosts(filepath.Join(t.TempDir(), "none"), []string{"i-0001"}); n != 0 || err != nil {
+		t.Errorf("expected no file is 0 without error but %d, %v", n, err)
+	}
+}
+
+func TestAppRunInstanceKnownHosts(t *testing.T) {
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n  instance_known_hosts = true\n"
+	a := newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+
+	if code := a.Run([]string{}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	path := knownHostsPath(a.RnsshDir)
+	expected := []string{"-oHostKeyAlias=i-0001", "-oUserKnownHostsFile=" + path, "-oHashKnownHosts=no", "203.0.113.1"}
+	if !reflect.DeepEqual(a.Recorder.Args, expected) {
+		t.Errorf("expected ssh args %v but %v", expected, a.Recorder.Args)
+	}
+
+	// host key checking is off.
+	if code := a.Run([]string{"-strict-host-key-checking-no", "1"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	expected = []string{"-oStrictHostKeyChecking=no", "-oUserKnownHostsFile=/dev/null", "203.0.113.1"}
+	if !reflect.DeepEqual(a.Recorder.Args, expected) {
+		t.Errorf("expected ssh args %v but %v", expected, a.Recorder.Args)
+	}
+}
+
+func TestRefreshCacheCleansKnownHosts(t *testing.T) {
+	dir := t.TempDir()
+	f := &fakeEC2{
+		Instances: []types.Instance{
+			newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+			newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2")),
+		},
+	}
+	h := newFakeEC2Handler(dir, f)
+	h.KnownHostsFile = knownHostsPath(dir)
+
+	content := "i-0001 ssh-ed25519 AAAA1\ni-0002 ssh-ed25519 AAAA2\ni-0003 ssh-ed25519 AAAA3\n"
+	if err := os.WriteFile(h.KnownHostsFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.RefreshCache("ap-northeast-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// first cache. i-0003 is not in cache (ex: other region), so it is kept.
+	if b, _ := os.ReadFile(h.KnownHostsFile); string(b) != content {
+		t.Errorf("expected known_hosts is not changed but %q", string(b))
+	}
+
+	f.Instances = f.Instances[1:]
+	if _, err := h.RefreshCache("ap-northeast-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "i-0002 ssh-ed25519 AAAA2\ni-0003 ssh-ed25519 AAAA3\n"
+	if b, _ := os.ReadFile(h.KnownHostsFile); string(b) != expected {
+		t.Errorf("expected %q but %q", expected, string(b))
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+)
+
+// lockFile does nothing without flock. concurrent refresh is not locked, but cache is still saved atomically.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -1,63 +1,14 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"strconv"
 	"strings"
-
-	"github.com/reiki4040/cstore"
-	"github.com/reiki4040/peco"
+	"time"
 )
 
 const (
-	Usage = `rnssh - easy ssh login to EC2.
-
-usage:
-
-  rnssh [-f] [-p] [-s] [user@]query strings ...
-  rnssh -init
-
-options:
-  -f: reload ec2 instances infomaion. connect to AWS.
-      you have to specify this option after you modified instances.
-	  (launch, start, stop, modify name, etc...)
-
-  -P: use Public IP address. this is default ssh host type.
-  -p: use Private IP address. for VPN/Direct connect.
-  -n: use Name tag.
-      this option for ssh config that Host named by ec2 Name tag.
-
-  -r: target region. you can set default by --init (~/.rnssh/config)
-
-  -s: show ssh command string that would be run. (debug)
-
-  -init: start wizard for default setting AWS region and rnssh host type.
-          and save to config file (~/.rnssh/config)
-
-options for ssh:
-  -l: ssh user.
-  -i: identity file path.
-  -port: ssh port.
-  -strict-host-key-checking-no: suppress host key checking.
-                                 1: using 'ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'
-                                 0: OFF
-                                -1: Default(OFF)
-
-options for help:
-  -h: show this usage.
-  -v: show version.
-
-args:
-  query string...: filtering ec2 instances list.
-
-notice:
-  breaking changes from 0.4.0:
-    modified option flag -- -> - (ex: --help -> -help)
-`
-
 	ENV_AWS_REGION      = "AWS_REGION"
 	ENV_RNSSH_HOST_TYPE = "RNSSH_HOST_TYPE"
 
@@ -67,6 +18,25 @@ notice:
 )
 
 type CommandOption struct {
+	ShowVersion bool
+	ShowUsage   bool
+	InitWizard  bool
+	ShowCommand bool
+
+	// -init uses default answers for options that are not specified. (no prompt)
+	NoPrompt bool
+
+	// instance id for preview pane (hidden option)
+	Describe string
+
+	// preview pane runs without project config trust prompt and with the same endpoint. (hidden options)
+	NoTrustPrompt bool
+	EC2Endpoint   string
+
+	// completion candidates kind (hidden option) and completion script shell
+	Complete   string
+	Completion string
+
 	Reload                  bool
 	Region                  string
 	PrivateIP               bool
@@ -76,8 +46,25 @@ type CommandOption struct {
 	IdentityFile            string
 	Port                    int
 	StrictHostKeyCheckingNo int
+	VerifyHostKey           bool
 	UseSshConfig            bool
 	UseEC2                  bool
+	CopyPassword            bool
+	Selector                string
+	Profile                 string
+	HostType                string
+
+	// for subcommands (cp, tunnel)
+	Recursive     bool
+	LocalForwards stringsFlag
+
+	// multi-host session in tmux (or screen)
+	Tmux      bool
+	TmuxPanes bool
+	TmuxSync  bool
+
+	// sessions play speed
+	PlaySpeed float64
 }
 
 func (o *CommandOption) Validate() error {
@@ -85,18 +72,38 @@ func (o *CommandOption) Validate() error {
 		return err
 	}
 
+	if o.HostType != "" && getSshTargetType(o.PublicIP, o.PrivateIP, o.NameTag) != "" {
+		return fmt.Errorf("can not specify both -host-type and -P/-p/-n")
+	}
+
+	if err := HostTypeCheck(o.HostType); err != nil {
+		return err
+	}
+
 	if err := IdentityFileCheck(o.IdentityFile); err != nil {
 		return err
 	}
 
+	if err := ProfileNameCheck(o.Profile); err != nil {
+		return err
+	}
+
 	if err := StrictHostKeyCheckingNoCheck(o.StrictHostKeyCheckingNo); err != nil {
 		return err
 	}
 
+	if err := SelectorCheck(o.Selector); err != nil {
+		return err
+	}
+
 	if o.UseSshConfig && o.UseEC2 {
 		return fmt.Errorf("can not specify both --use-ssh-config and --use-ec2")
 	}
 
+	if (o.TmuxPanes || o.TmuxSync) && !o.Tmux {
+		return fmt.Errorf("-tmux-panes and -tmux-sync need -tmux")
+	}
+
 	return nil
 }
 
@@ -114,254 +121,178 @@ type RnsshOption struct {
 	HostType                string
 	SshUser                 string
 	IdentityFile            string
+	IdentityFileTemplate    string
+	IdentityFileByKeyName   map[string]string
 	Port                    int
 	StrictHostKeyCheckingNo int
 	UseSshConfig            bool
+	EC2Endpoint             string
+	NetworkInterface        string
+	SshUserTag              string
+	SshUserByAMI            map[string]string
+	RdpCommand              string
+	CopyPassword            bool
+	Selector                string
+	SelectorOptions         []string
+	CacheTTL                time.Duration
+
+	// rnssh known_hosts for EC2 instances. empty is not used.
+	KnownHostsFile string
+	VerifyHostKey  bool
+
+	// ssh user certificate. CertificateFile is set after the certificate is checked.
+	Profile         string
+	CertSignCommand string
+	CertSignerURL   string
+	CertPublicKey   string
+	CertificateFile string
+
+	// ssh agent. IdentityFileInAgent is set after the key is loaded in agent.
+	Agent               bool
+	AgentLifetime       time.Duration
+	AgentSocket         string
+	IdentityFileInAgent string
+
+	SessionRecording bool
+
+	// merged config and config key -> source (flag, config, env, default). for config show.
+	Merged  *RnsshConfig
+	Sources map[string]string
 }
 
 var (
 	version  string
 	revision string
-
-	show_version bool
-	show_usage   bool
-	initWizard   bool
-	showCommand  bool
-
-	// command option
-	opt = &CommandOption{}
 )
 
-func init() {
-	flag.BoolVar(&show_version, "version", false, "show version.")
-	flag.BoolVar(&show_version, "v", false, "show version.")
-	flag.BoolVar(&show_usage, "h", false, "show this usage.")
-	flag.BoolVar(&show_usage, "help", false, "show this usage.")
-	flag.BoolVar(&initWizard, "init", false, "run initial configuration wizard.")
-
-	flag.BoolVar(&opt.Reload, "f", false, "reload ec2 (force connect to AWS)")
-	flag.BoolVar(&opt.Reload, "force", false, "reload ec2 (force connect to AWS)")
-	flag.BoolVar(&opt.PublicIP, "P", false, "ssh with EC2 Public IP")
-	flag.BoolVar(&opt.PublicIP, "public-ip", false, "ssh with EC2 Public IP")
-	flag.BoolVar(&opt.PrivateIP, "p", false, "ssh with EC2 Private IP")
-	flag.BoolVar(&opt.PrivateIP, "private-ip", false, "ssh with EC2 Private IP")
-	flag.BoolVar(&opt.NameTag, "n", false, "ssh with EC2 Name tag")
-	flag.BoolVar(&opt.NameTag, "name-tag", false, "ssh with EC2 Name tag")
-	flag.BoolVar(&showCommand, "s", false, "show ssh command that will do (debug)")
-	flag.BoolVar(&showCommand, "show-command", false, "show ssh command that will do (debug)")
-
-	flag.StringVar(&opt.Region, "r", "", "specify region")
-	flag.StringVar(&opt.Region, "region", "", "specify region")
-
-	flag.StringVar(&opt.SshUser, "l", "", "specify ssh user")
-	flag.StringVar(&opt.SshUser, "user", "", "specify ssh user")
-	flag.StringVar(&opt.IdentityFile, "i", "", "specify ssh identity file")
-	flag.StringVar(&opt.IdentityFile, "identity-file", "", "specify ssh identity file")
-	flag.IntVar(&opt.Port, "port", 0, "specify ssh port")
-	flag.IntVar(&opt.StrictHostKeyCheckingNo, "strict-host-key-checking-no", -1, "suppress host key checking. 1: ON, 0: OFF, -1: default(OFF)")
-
-	flag.BoolVar(&opt.UseSshConfig, "use-ssh-config", false, "load from ssh config")
-	flag.BoolVar(&opt.UseEC2, "use-ec2", false, "load from ec2")
-
-	flag.Parse()
-}
-
-func showVersion() {
-	fmt.Printf("%s (%s)", version, revision)
-}
-
-func usage() {
-	fmt.Printf("%s\n", Usage)
-}
-
 func main() {
-	if show_usage {
-		usage()
-		os.Exit(0)
-	}
-
-	if show_version {
-		showVersion()
-		os.Exit(0)
-	}
-
-	err := opt.Validate()
-	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
-	}
-
-	m, err := cstore.NewManager("rnssh", getRnsshDir())
-	if err != nil {
-		fmt.Printf("can not create rnssh dir: %s\n", err.Error())
-		os.Exit(1)
-	}
-
-	cs, err := m.New("config", cstore.TOML)
-	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
-	}
-
-	if initWizard {
-		if err := DoConfigWizard(cs); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		} else {
-			fmt.Println("saved rnssh config.")
-			os.Exit(0)
-		}
-	}
-
-	conf := Config{}
-	err = cs.Get(&conf)
-	if err != nil && !os.IsNotExist(err) {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
-	}
-
-	rOpt := mergeConfig(&conf.Default, *opt)
-	if !rOpt.UseSshConfig && rOpt.Region == "" {
-		fmt.Println("region is empty. please specify by region option (-r) or set default region with --init option")
-		os.Exit(1)
-	}
+	os.Exit(NewApp().Run(os.Args[1:]))
+}
 
-	sshArgs, err := chooseAndGenSshArgs(rOpt, flag.Args(), m)
+// merge option, config files, ENV
+// priority [high] option > config files (later is prior) > ENV > default [low]
+// RNSSH_ENV_OVERRIDE=1 changes to [high] option > ENV > config files > default [low]
+func mergeConfig(opt CommandOption, confs ...configLayer) (*RnsshOption, error) {
+	env, envNames, err := envConfig()
 	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
+		return nil, err
 	}
 
-	if showCommand {
-		fmt.Printf("%s %s\n", "ssh", strings.Join(sshArgs, " "))
-		os.Exit(0)
+	envKeys := make(map[string]bool, len(envNames))
+	for key := range envNames {
+		envKeys[key] = true
 	}
 
-	cmd := exec.Command("ssh", sshArgs...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Run()
-}
-
-// merge option, config, ENV
-// priority [high] option > config > ENV [low]
-func mergeConfig(conf *RnsshConfig, opt CommandOption) *RnsshOption {
-
-	region := os.Getenv(ENV_AWS_REGION)
-	if opt.Region != "" {
-		region = opt.Region
+	envLayer := configLayer{Source: SOURCE_ENV, Config: env, Keys: envKeys}
+	layers := []configLayer{{Source: SOURCE_DEFAULT, Config: defaultConfig()}}
+	if envOverride() {
+		layers = append(append(layers, confs...), envLayer)
 	} else {
-		if conf.AWSRegion != "" {
-			region = conf.AWSRegion
-		}
+		layers = append(append(layers, envLayer), confs...)
 	}
+	layers = append(layers, configLayer{Source: SOURCE_FLAG, Config: optionConfig(&opt)})
 
-	hostType := os.Getenv(ENV_RNSSH_HOST_TYPE)
-	optHostType := getSshTargetType(opt.PublicIP, opt.PrivateIP, opt.NameTag)
-	if optHostType != "" {
-		hostType = optHostType
-	} else {
-		if conf.HostType != "" {
-			hostType = conf.HostType
+	merged, sources := mergeConfigLayers(layers...)
+	for key, source := range sources {
+		if source == SOURCE_ENV {
+			sources[key] = SOURCE_ENV + ":" + envNames[key]
 		}
 	}
 
-	sshUser := conf.SshUser
-	if opt.SshUser != "" {
-		sshUser = opt.SshUser
+	// 0 is OFF that overrides config.
+	if opt.StrictHostKeyCheckingNo == 0 {
+		merged.SshStrictHostKeyCheckingNo = 0
+		sources["ssh_strict_host_key_checking_no"] = SOURCE_FLAG
 	}
 
-	identityFile := conf.SshIdentityFile
+	// -i option is used for all instances.
 	if opt.IdentityFile != "" {
-		identityFile = opt.IdentityFile
-	}
-
-	port := conf.SshPort
-	if opt.Port > 0 {
-		port = opt.Port
-	}
-
-	strictHostKeyCheckingNo := conf.SshStrictHostKeyCheckingNo
-	if opt.StrictHostKeyCheckingNo != -1 {
-		strictHostKeyCheckingNo = opt.StrictHostKeyCheckingNo
-	}
-
-	useSshConfig := conf.UseSshConfig
-	if opt.UseSshConfig {
-		useSshConfig = true
+		merged.SshIdentityFileTemplate = ""
+		merged.SshIdentityFileByKeyName = nil
+		delete(sources, "ssh_identity_file_template")
+		delete(sources, "ssh_identity_file_by_key_name")
 	}
 
 	if opt.UseEC2 {
-		useSshConfig = false
+		merged.UseSshConfig = false
+		sources["use_ssh_config"] = SOURCE_FLAG
 	}
 
+	// already validated.
+	cacheTTL, _ := time.ParseDuration(merged.CacheTTL)
+	agentLifetime, _ := time.ParseDuration(merged.SshAgentLifetime)
+
 	return &RnsshOption{
 		Reload:                  opt.Reload,
-		Region:                  region,
-		HostType:                hostType,
-		SshUser:                 sshUser,
-		IdentityFile:            identityFile,
-		Port:                    port,
-		StrictHostKeyCheckingNo: strictHostKeyCheckingNo,
-		UseSshConfig:            useSshConfig,
-	}
+		Region:                  merged.AWSRegion,
+		HostType:                merged.HostType,
+		SshUser:                 merged.SshUser,
+		IdentityFile:            merged.SshIdentityFile,
+		IdentityFileTemplate:    merged.SshIdentityFileTemplate,
+		IdentityFileByKeyName:   merged.SshIdentityFileByKeyName,
+		Port:                    merged.SshPort,
+		StrictHostKeyCheckingNo: merged.SshStrictHostKeyCheckingNo,
+		UseSshConfig:            merged.UseSshConfig,
+		EC2Endpoint:             merged.EC2Endpoint,
+		NetworkInterface:        merged.NetworkInterface,
+		SshUserTag:              merged.SshUserTag,
+		SshUserByAMI:            merged.SshUserByAMI,
+		RdpCommand:              merged.RdpCommand,
+		CopyPassword:            opt.CopyPassword,
+		Selector:                merged.Selector,
+		SelectorOptions:         merged.SelectorOptions,
+		CacheTTL:                cacheTTL,
+		VerifyHostKey:           merged.VerifyHostKey,
+		CertSignCommand:         merged.SshCertSignCommand,
+		CertSignerURL:           merged.SshCertSignerURL,
+		CertPublicKey:           merged.SshCertPublicKey,
+		Agent:                   merged.SshAgent,
+		AgentLifetime:           agentLifetime,
+		AgentSocket:             merged.SshAgentSocket,
+		SessionRecording:        merged.SessionRecording,
+		Merged:                  merged,
+		Sources:                 sources,
+	}, nil
 }
 
-func chooseAndGenSshArgs(rOpt *RnsshOption, cmdArgs []string, manager *cstore.Manager) ([]string, error) {
-
-	// support user@host format
-	sshUser, hostname, err := getSshUserAndHostname(strings.Join(cmdArgs, " "))
-	if err != nil {
-		return nil, err
+func defaultConfig() *RnsshConfig {
+	return &RnsshConfig{
+		HostType:   HOST_TYPE_PUBLIC_IP,
+		Selector:   SELECTOR_PECO,
+		SshUserTag: DEFAULT_SSH_USER_TAG,
 	}
+}
 
-	hostType := HOST_TYPE_PUBLIC_IP
-	if rOpt.HostType != "" {
-		hostType = rOpt.HostType
+// optionConfig converts command line options to config layer.
+func optionConfig(opt *CommandOption) *RnsshConfig {
+	hostType := getSshTargetType(opt.PublicIP, opt.PrivateIP, opt.NameTag)
+	if opt.HostType != "" {
+		hostType = opt.HostType
 	}
 
-	var choosableList []peco.Choosable
-	if rOpt.UseSshConfig {
-		var err error
-		choosableList, err = LoadSshConfigChoosableList()
-		if err != nil {
-			fmt.Printf("%s\n", err.Error())
-			os.Exit(1)
-		}
-
-		if len(choosableList) == 0 {
-			fmt.Println("ssh config does not have host settings")
-			os.Exit(1)
-		}
-	} else {
-		var err error
-		handler := NewEC2Handler(manager)
-		choosableList, err = handler.LoadTargetHost(hostType, rOpt.Region, rOpt.Reload)
-		if err != nil {
-			fmt.Printf("%s\n", err.Error())
-			os.Exit(1)
-		}
-
-		if len(choosableList) == 0 {
-			fmt.Printf("there is no instance. not running %s\n", rOpt.Region)
-			os.Exit(1)
-		}
+	// -1 is not specified.
+	strictHostKeyCheckingNo := 0
+	if opt.StrictHostKeyCheckingNo == 1 {
+		strictHostKeyCheckingNo = 1
 	}
 
-	// show ec2 instances and choose intactive
-	targetHosts, err := peco.Choose("server", "which servers connect with ssh?", hostname, choosableList)
-	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
+	return &RnsshConfig{
+		AWSRegion:                  opt.Region,
+		HostType:                   hostType,
+		SshUser:                    opt.SshUser,
+		SshIdentityFile:            opt.IdentityFile,
+		SshPort:                    opt.Port,
+		SshStrictHostKeyCheckingNo: strictHostKeyCheckingNo,
+		UseSshConfig:               opt.UseSshConfig,
+		Selector:                   opt.Selector,
+		VerifyHostKey:              opt.VerifyHostKey,
+		EC2Endpoint:                opt.EC2Endpoint,
 	}
+}
 
-	l := len(targetHosts) - 1
-	targetHost := targetHosts[l]
-	sshHost := targetHost.Value()
-	sshArgs := genSshArgs(rOpt.SshUser, rOpt.IdentityFile, rOpt.Port, rOpt.StrictHostKeyCheckingNo, sshUser, sshHost)
-
-	return sshArgs, nil
+// ssh via AWS Systems Manager Session Manager. ssh host is instance id.
+func genSsmProxyCommandOption(region string) string {
+	return "ProxyCommand=aws ssm start-session --target %h --document-name AWS-StartSSHSession --parameters portNumber=%p --region " + region
 }
 
 func getSshUserAndHostname(sshTarget string) (string, string, error) {
@@ -395,7 +326,7 @@ func getSshTargetType(publicIP, privateIP, nameTag bool) string {
 	return ""
 }
 
-func genSshArgs(optSshUser, optIdentityFile string, optPort, optStrictHostKeyCheckingNo int, sshUser, sshHost string) []string {
+func genSshArgs(optSshUser, optIdentityFile string, optPort, optStrictHostKeyCheckingNo int, sshOptions []string, sshUser, sshHost string) []string {
 	args := make([]string, 0)
 	if optSshUser != "" {
 		args = append(args, "-l"+optSshUser)
@@ -414,6 +345,10 @@ func genSshArgs(optSshUser, optIdentityFile string, optPort, optStrictHostKeyChe
 		args = append(args, "-oUserKnownHostsFile=/dev/null")
 	}
 
+	for _, o := range sshOptions {
+		args = append(args, "-o"+o)
+	}
+
 	if sshUser != "" {
 		sshHost = sshUser + "@" + sshHost
 	}
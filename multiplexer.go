@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/reiki4040/peco"
+)
+
+const (
+	MULTIPLEXER_TMUX   = "tmux"
+	MULTIPLEXER_SCREEN = "screen"
+
+	ENV_TMUX = "TMUX"
+
+	// session name prefix of multi-host session. (rnssh-<pid>)
+	MULTIPLEXER_SESSION_PREFIX = "rnssh"
+)
+
+// multiplexerWindow is one ssh of multi-host session.
+type multiplexerWindow struct {
+	Name    string
+	SshArgs []string
+}
+
+// multiplexerWindowName returns Name tag, instance id or host.
+func multiplexerWindowName(targetHost peco.Choosable) string {
+	if e, ok := targetHost.(*ChoosableEC2); ok {
+		if e.Name != "" {
+			return e.Name
+		}
+		return e.InstanceId
+	}
+
+	return targetHost.Value()
+}
+
+// multiplexer returns tmux, or screen if tmux is not installed.
+func (a *App) multiplexer() (string, error) {
+	for _, m := range []string{MULTIPLEXER_TMUX, MULTIPLEXER_SCREEN} {
+		if _, err := a.LookPath(m); err == nil {
+			return m, nil
+		}
+	}
+
+	return "", fmt.Errorf("tmux and screen are not found. please install tmux")
+}
+
+// genTmuxCommands returns tmux commands that open a window (or pane) per host in new session.
+func genTmuxCommands(session string, windows []*multiplexerWindow, panes, sync, inTmux bool) [][]string {
+	cmds := make([][]string, 0, len(windows)+3)
+	for i, w := range windows {
+		var cmd []string
+		switch {
+		case i == 0:
+			cmd = []string{"new-session", "-d", "-s", session, "-n", w.Name}
+		case panes:
+			cmd = []string{"split-window", "-t", session}
+		default:
+			cmd = []string{"new-window", "-t", session, "-n", w.Name}
+		}
+		cmds = append(cmds, append(append(cmd, "--", "ssh"), w.SshArgs...))
+
+		// split-window fails if pane is too small, so layout every time.
+		if panes && i > 0 {
+			cmds = append(cmds, []string{"select-layout", "-t", session, "tiled"})
+		}
+	}
+
+	if panes && sync {
+		cmds = append(cmds, []string{"set-window-option", "-t", session, "synchronize-panes", "on"})
+	}
+
+	// nested attach is not allowed in tmux.
+	if inTmux {
+		cmds = append(cmds, []string{"switch-client", "-t", session})
+	} else {
+		cmds = append(cmds, []string{"attach-session", "-t", session})
+	}
+
+	return cmds
+}
+
+// genScreenCommands returns GNU screen commands that open a window per host in new session.
+func genScreenCommands(session string, windows []*multiplexerWindow) [][]string {
+	cmds := make([][]string, 0, len(windows)+1)
+	for i, w := range windows {
+		var cmd []string
+		if i == 0 {
+			cmd = []string{"-dmS", session, "-t", w.Name}
+		} else {
+			cmd = []string{"-S", session, "-X", "screen", "-t", w.Name}
+		}
+		cmds = append(cmds, append(append(cmd, "ssh"), w.SshArgs...))
+	}
+
+	return append(cmds, []string{"-r", session})
+}
+
+// openMultiplexer opens ssh to the hosts in tmux (or screen) session.
+func (a *App) openMultiplexer(opt *CommandOption, windows []*multiplexerWindow) error {
+	m, err := a.multiplexer()
+	if err != nil {
+		return err
+	}
+
+	session := fmt.Sprintf("%s-%d", MULTIPLEXER_SESSION_PREFIX, os.Getpid())
+
+	var cmds [][]string
+	if m == MULTIPLEXER_TMUX {
+		cmds = genTmuxCommands(session, windows, opt.TmuxPanes || opt.TmuxSync, opt.TmuxSync, os.Getenv(ENV_TMUX) != "")
+	} else {
+		if opt.TmuxPanes || opt.TmuxSync {
+			fmt.Fprintf(a.Stderr, "warn: tmux is not found. screen opens windows without panes and synchronize.\n")
+		}
+		cmds = genScreenCommands(session, windows)
+	}
+
+	for _, cmd := range cmds {
+		if err := a.runOrShow(opt.ShowCommand, m, cmd); err != nil {
+			return fmt.Errorf("%s %s failed: %s", m, strings.Join(cmd[:min(len(cmd), 2)], " "), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// sshMultiplexer chooses hosts and opens ssh to each host in tmux (or screen).
+func (a *App) sshMultiplexer(rOpt *RnsshOption, handler *EC2Handler, opt *CommandOption, queries []string, remoteCommand ...string) error {
+	if rOpt.SessionRecording {
+		return fmt.Errorf("session recording does not support -tmux. please connect to each host without -tmux")
+	}
+
+	targetHosts, sshUser, err := a.chooseHosts(rOpt, handler, queries)
+	if err != nil {
+		return err
+	}
+
+	// all chosen hosts are opened, so check them before connect.
+	for _, targetHost := range targetHosts {
+		if err := checkAvailable(targetHost); err != nil {
+			return err
+		}
+	}
+
+	windows := make([]*multiplexerWindow, 0, len(targetHosts))
+	for _, targetHost := range targetHosts {
+		if e, ok := targetHost.(*ChoosableEC2); ok && e.IsWindows() {
+			return fmt.Errorf("can not ssh to windows instance %s (%s) in %s", e.InstanceId, e.Name, MULTIPLEXER_TMUX)
+		}
+
+		if err := a.beforeConnect(rOpt, handler, targetHost, opt.ShowCommand); err != nil {
+			return err
+		}
+
+		sshArgs, err := genSshArgsForHost(rOpt, targetHost, sshUser)
+		if err != nil {
+			return err
+		}
+
+		windows = append(windows, &multiplexerWindow{Name: multiplexerWindowName(targetHost), SshArgs: append(sshArgs, remoteCommand...)})
+	}
+
+	return a.openMultiplexer(opt, windows)
+}
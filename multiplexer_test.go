@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+
+	"github.com/reiki4040/peco"
+)
+
+// multiSelector chooses all hosts that contain the query.
+type multiSelector struct{}
+
+func (s *multiSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	chosen := make([]peco.Choosable, 0)
+	for _, c := range choices {
+		if strings.Contains(c.Choice(), defaultQuery) {
+			chosen = append(chosen, c)
+		}
+	}
+
+	return chosen, nil
+}
+
+// orderedSelector chooses hosts that contain each pick in order of picks. (order of selection)
+type orderedSelector struct {
+	Picks []string
+}
+
+func (s *orderedSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	chosen := make([]peco.Choosable, 0, len(s.Picks))
+	for _, p := range s.Picks {
+		for _, c := range choices {
+			if strings.Contains(c.Choice(), p) {
+				chosen = append(chosen, c)
+			}
+		}
+	}
+
+	return chosen, nil
+}
+
+func newMultiplexerTestApp(t *testing.T) (*testApp, *[][]string) {
+	t.Helper()
+	t.Setenv(ENV_TMUX, "")
+
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")),
+		newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2")),
+		newFakeInstance("i-0003", "db1", withPublicIP("203.0.113.3")),
+	}
+	a := newTestApp(t, "", "[Default]\n  aws_region = \"ap-northeast-1\"\n", instances...)
+	a.App.Selector = &multiSelector{}
+
+	cmds := make([][]string, 0)
+	a.RunCommand = func(name string, args ...string) error {
+		cmds = append(cmds, append([]string{name}, args...))
+		return nil
+	}
+
+	return a, &cmds
+}
+
+func TestAppRunTmux(t *testing.T) {
+	session := fmt.Sprintf("rnssh-%d", os.Getpid())
+
+	tests := []struct {
+		name   string
+		args   []string
+		inTmux bool
+		cmds   [][]string
+	}{
+		{
+			name: "windows",
+			args: []string{"-tmux", "-l", "ec2-user", "web"},
+			cmds: [][]string{
+				{"tmux", "new-session", "-d", "-s", session, "-n", "web1", "--", "ssh", "-lec2-user", "203.0.113.1"},
+				{"tmux", "new-window", "-t", session, "-n", "web2", "--", "ssh", "-lec2-user", "203.0.113.2"},
+				{"tmux", "attach-session", "-t", session},
+			},
+		},
+		{
+			name:   "synchronized panes in tmux",
+			args:   []string{"-tmux", "-tmux-sync", "web"},
+			inTmux: true,
+			cmds: [][]string{
+				{"tmux", "new-session", "-d", "-s", session, "-n", "web1", "--", "ssh", "203.0.113.1"},
+				{"tmux", "split-window", "-t", session, "--", "ssh", "203.0.113.2"},
+				{"tmux", "select-layout", "-t", session, "tiled"},
+				{"tmux", "set-window-option", "-t", session, "synchronize-panes", "on"},
+				{"tmux", "switch-client", "-t", session},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, cmds := newMultiplexerTestApp(t)
+			if tt.inTmux {
+				t.Setenv(ENV_TMUX, "/tmp/tmux-1000/default,1,0")
+			}
+
+			if code := a.Run(tt.args); code != 0 {
+				t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+			}
+
+			if !reflect.DeepEqual(*cmds, tt.cmds) {
+				t.Errorf("expected commands\n%v\nbut\n%v", tt.cmds, *cmds)
+			}
+		})
+	}
+}
+
+func TestAppRunTmuxScreenFallback(t *testing.T) {
+	a, cmds := newMultiplexerTestApp(t)
+	a.LookPath = func(file string) (string, error) {
+		if file == MULTIPLEXER_SCREEN {
+			return "/usr/bin/screen", nil
+		}
+		return "", fmt.Errorf("not found")
+	}
+
+	if code := a.Run([]string{"ssh", "-tmux", "-tmux-panes", "web"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	session := fmt.Sprintf("rnssh-%d", os.Getpid())
+	expected := [][]string{
+		{"screen", "-dmS", session, "-t", "web1", "ssh", "203.0.113.1"},
+		{"screen", "-S", session, "-X", "screen", "-t", "web2", "ssh", "203.0.113.2"},
+		{"screen", "-r", session},
+	}
+	if !reflect.DeepEqual(*cmds, expected) {
+		t.Errorf("expected commands\n%v\nbut\n%v", expected, *cmds)
+	}
+
+	if !strings.Contains(a.Out.String(), "warn: tmux is not found") {
+		t.Errorf("expected warning of panes but %q", a.Out.String())
+	}
+
+	a.LookPath = func(file string) (string, error) {
+		return "", fmt.Errorf("not found")
+	}
+	a.Out.Reset()
+	if code := a.Run([]string{"-tmux", "web"}); code != 1 || !strings.Contains(a.Out.String(), "tmux and screen are not found") {
+		t.Errorf("expected error of no multiplexer but %d: %q", code, a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-tmux-sync", "web"}); code != 1 || !strings.Contains(a.Out.String(), "need -tmux") {
+		t.Errorf("expected error of -tmux-sync without -tmux but %d: %q", code, a.Out.String())
+	}
+}
+
+func TestAppRunMultipleChosenUnavailable(t *testing.T) {
+	t.Setenv(ENV_TMUX, "")
+
+	instances := []types.Instance{
+		newFakeInstance("i-0001", "web1", withPrivateIP("10.0.0.1")),
+		newFakeInstance("i-0002", "web2", withPublicIP("203.0.113.2")),
+	}
+	a := newTestApp(t, "", "[Default]\n  aws_region = \"ap-northeast-1\"\n", instances...)
+	a.App.Selector = &orderedSelector{Picks: []string{"web1", "web2"}}
+
+	// single host connects to the last chosen host, so others are not checked.
+	if code := a.Run([]string{"web"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if a.Recorder.Name != "ssh" || !reflect.DeepEqual(a.Recorder.Args, []string{"203.0.113.2"}) {
+		t.Errorf("expected ssh to web2 but %s %v", a.Recorder.Name, a.Recorder.Args)
+	}
+
+	a.Recorder.Name = ""
+	if code := a.Run([]string{"-tmux", "web"}); code != 1 || !strings.Contains(a.Out.String(), "can not connect to i-0001 (web1): no public address") {
+		t.Errorf("expected error of unavailable host but %d: %q", code, a.Out.String())
+	}
+
+	if a.Recorder.Name != "" {
+		t.Errorf("expected tmux is not run but %s %v", a.Recorder.Name, a.Recorder.Args)
+	}
+}
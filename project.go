@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/reiki4040/cstore"
+)
+
+const (
+	// project local config that is searched from working dir to upwards.
+	PROJECT_CONFIG_NAME = ".rnssh.toml"
+
+	// project config path -> sha256 of trusted content.
+	TRUSTED_CONFIGS_FILE_NAME = "trusted_configs.json"
+)
+
+// configFile is loaded config file. Source is SOURCE_CONFIG or SOURCE_PROJECT.
+type configFile struct {
+	Source string
+	Path   string
+	Config *Config
+}
+
+// findProjectConfig returns the nearest .rnssh.toml from dir to upwards. empty is not found.
+func findProjectConfig(dir string) string {
+	if dir == "" {
+		return ""
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+
+	for {
+		path := filepath.Join(dir, PROJECT_CONFIG_NAME)
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			return path
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func loadProjectConfig(path string) (*Config, []string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can not read project config %s: %s", path, err.Error())
+	}
+
+	return decodeProjectConfig(path, content)
+}
+
+// decodeProjectConfig decodes and validates project config. it returns warnings. (see DecodeConfig)
+func decodeProjectConfig(path string, content []byte) (*Config, []string, error) {
+	conf, warnings, err := DecodeConfig(content)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can not read project config %s: %s", path, err.Error())
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid project config %s: %s", path, err.Error())
+	}
+
+	return conf, warnings, nil
+}
+
+// configLayers returns the profile config of each file. (later file is prior)
+// the profile must be defined in one of the files at least. file without the profile uses its Default.
+func configLayers(files []*configFile, profile string) ([]configLayer, error) {
+	layers := make([]configLayer, 0, len(files))
+	found := profile == ""
+	for _, f := range files {
+		c := &f.Config.Default
+		if profile != "" && hasProfile(f.Config, profile) {
+			var err error
+			c, err = f.Config.Profile(profile)
+			if err != nil {
+				return nil, err
+			}
+			found = true
+		}
+
+		layers = append(layers, configLayer{Source: f.Source + ":" + f.Path, Config: c, Keys: f.Config.DefinedKeys(profile)})
+	}
+
+	if !found {
+		return nil, fmt.Errorf("profile is not found: %s", profile)
+	}
+
+	return layers, nil
+}
+
+func hasProfile(c *Config, name string) bool {
+	for _, n := range c.ProfileNames() {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func fileSha256(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return contentSha256(content), nil
+}
+
+func contentSha256(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func trustedConfigsPath(rnsshDir string) string {
+	return filepath.Join(rnsshDir, TRUSTED_CONFIGS_FILE_NAME)
+}
+
+func loadTrustedConfigs(rnsshDir string) (map[string]string, error) {
+	trusted := make(map[string]string)
+	if err := cstore.LoadFromJsonFile(trustedConfigsPath(rnsshDir), &trusted); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("can not read trusted configs: %s", err.Error())
+	}
+
+	return trusted, nil
+}
+
+func saveTrustedConfigs(rnsshDir string, trusted map[string]string) error {
+	return writeFileAtomic(trustedConfigsPath(rnsshDir), func(w io.Writer) error {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(trusted)
+	})
+}
+
+// TrustConfig saves sha256 of the current content of path.
+func TrustConfig(rnsshDir, path string) error {
+	trusted, err := loadTrustedConfigs(rnsshDir)
+	if err != nil {
+		return err
+	}
+
+	sum, err := fileSha256(path)
+	if err != nil {
+		return err
+	}
+
+	trusted[path] = sum
+	return saveTrustedConfigs(rnsshDir, trusted)
+}
+
+// UntrustConfig removes path from trusted configs.
+func UntrustConfig(rnsshDir, path string) error {
+	trusted, err := loadTrustedConfigs(rnsshDir)
+	if err != nil {
+		return err
+	}
+
+	delete(trusted, path)
+	return saveTrustedConfigs(rnsshDir, trusted)
+}
+
+// confirmTrust asks user to trust the project config. (y/N)
+func confirmTrust(in io.Reader, out io.Writer, path string) bool {
+	fmt.Fprintf(out, "found project config %s. it is new or changed.\n", path)
+	fmt.Fprint(out, "trust and use it? (it can change ssh host, user and command) [y/N]: ")
+
+	line, _ := bufio.NewReader(in).ReadString('\n')
+	if !strings.HasSuffix(line, "\n") {
+		// no input (EOF)
+		fmt.Fprintln(out)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
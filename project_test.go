@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func writeProjectConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, PROJECT_CONFIG_NAME)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestFindProjectConfig(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "a", "b")
+	if err := os.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if p := findProjectConfig(sub); p != "" {
+		t.Errorf("expected not found but %s", p)
+	}
+
+	path := writeProjectConfig(t, root, "")
+	if p := findProjectConfig(sub); p != path {
+		t.Errorf("expected %s but %s", path, p)
+	}
+
+	nearest := writeProjectConfig(t, filepath.Join(root, "a"), "")
+	if p := findProjectConfig(sub); p != nearest {
+		t.Errorf("expected %s but %s", nearest, p)
+	}
+}
+
+func TestAppRunProjectConfig(t *testing.T) {
+	config := `[Default]
+  aws_region = "us-west-2"
+  ssh_user = "ec2-user"
+`
+	a := newTestApp(t, "", config)
+	a.WorkDir = t.TempDir()
+	path := writeProjectConfig(t, a.WorkDir, `[Default]
+  ssh_user = "deploy"
+
+[[profiles]]
+  profile_name = "prod"
+  aws_region = "eu-west-1"
+`)
+
+	// not trusted
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "ignored project config "+path) || strings.Contains(a.Out.String(), "deploy") {
+		t.Errorf("expected untrusted project config is ignored but %q", a.Out.String())
+	}
+
+	// trust with prompt
+	a.Out.Reset()
+	a.Stdin = strings.NewReader("y\n")
+	if code := a.Run([]string{"config", "show", "-profile", "prod"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	for _, s := range []string{`(?m)^project: `, `(?m)^ssh_user\s+deploy\s+project:`, `(?m)^aws_region\s+eu-west-1\s+project:`} {
+		if !regexp.MustCompile(s + regexp.QuoteMeta(path)).MatchString(a.Out.String()) {
+			t.Errorf("expected %q but %q", s, a.Out.String())
+		}
+	}
+
+	// trusted, no prompt
+	a.Out.Reset()
+	a.Stdin = strings.NewReader("")
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if strings.Contains(a.Out.String(), "trust and use it?") || !strings.Contains(a.Out.String(), "deploy") {
+		t.Errorf("expected trusted project config is used without prompt but %q", a.Out.String())
+	}
+
+	// changed content asks again
+	writeProjectConfig(t, a.WorkDir, "[Default]\n  ssh_user = \"attacker\"\n")
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "trust and use it?") || strings.Contains(a.Out.String(), "attacker") {
+		t.Errorf("expected changed project config is ignored but %q", a.Out.String())
+	}
+
+	// preview pane ignores untrusted project config without prompt
+	a.Out.Reset()
+	a.Stdin = strings.NewReader("y\n")
+	a.Run([]string{"-describe", "i-0001", "-no-trust-prompt"})
+	if strings.Contains(a.Out.String(), "trust and use it?") || strings.Contains(a.Out.String(), "ignored project config") {
+		t.Errorf("expected no prompt and no warning with -no-trust-prompt but %q", a.Out.String())
+	}
+
+	// trust command
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "trust"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	a.Out.Reset()
+	a.Stdin = strings.NewReader("")
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if strings.Contains(a.Out.String(), "trust and use it?") || !strings.Contains(a.Out.String(), "attacker") {
+		t.Errorf("expected project config trusted by command is used without prompt but %q", a.Out.String())
+	}
+
+	if code := a.Run([]string{"config", "untrust"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"config", "show"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "trust and use it?") || strings.Contains(a.Out.String(), "attacker") {
+		t.Errorf("expected untrusted project config asks again but %q", a.Out.String())
+	}
+}
+
+func TestConfigLayersProfileNotFound(t *testing.T) {
+	files := []*configFile{
+		{Source: SOURCE_CONFIG, Path: "config", Config: &Config{}},
+		{Source: SOURCE_PROJECT, Path: PROJECT_CONFIG_NAME, Config: &Config{Profiles: []RnsshConfig{{Name: "prod"}}}},
+	}
+
+	if _, err := configLayers(files, "prod"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := configLayers(files, "staging"); err == nil {
+		t.Errorf("expected profile not found error")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func openPty() (*os.File, string, error) {
+	return nil, "", fmt.Errorf("pseudo terminal is not supported on this OS")
+}
+
+func isTerminal(f *os.File) bool {
+	return false
+}
+
+func makeRaw(f *os.File) (func(), error) {
+	return nil, fmt.Errorf("raw terminal is not supported on this OS")
+}
+
+func terminalSize(f *os.File) (int, int, error) {
+	return 0, 0, fmt.Errorf("terminal size is not supported on this OS")
+}
+
+func setTerminalSize(f *os.File, cols, rows int) error {
+	return fmt.Errorf("terminal size is not supported on this OS")
+}
+
+func startPty(cmd *exec.Cmd, cols, rows int) (*os.File, error) {
+	return nil, fmt.Errorf("session recording is not supported on this OS")
+}
+
+func notifyResize(resize func()) func() {
+	return func() {}
+}
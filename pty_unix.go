@@ -0,0 +1,114 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	Row    uint16
+	Col    uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+func ioctl(fd, req, arg uintptr) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	var t syscall.Termios
+	return ioctl(f.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&t))) == nil
+}
+
+// makeRaw sets terminal to raw mode (same as cfmakeraw) and returns restore function.
+func makeRaw(f *os.File) (func(), error) {
+	var old syscall.Termios
+	if err := ioctl(f.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&old))); err != nil {
+		return nil, err
+	}
+
+	raw := old
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Oflag &^= syscall.OPOST
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cflag &^= syscall.CSIZE | syscall.PARENB
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ioctl(f.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(&old)))
+	}, nil
+}
+
+// terminalSize returns columns and rows of the terminal.
+func terminalSize(f *os.File) (int, int, error) {
+	var ws winsize
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws))); err != nil {
+		return 0, 0, err
+	}
+
+	return int(ws.Col), int(ws.Row), nil
+}
+
+func setTerminalSize(f *os.File, cols, rows int) error {
+	ws := winsize{Row: uint16(rows), Col: uint16(cols)}
+	return ioctl(f.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+// startPty starts command with pseudo terminal as its controlling terminal.
+func startPty(cmd *exec.Cmd, cols, rows int) (*os.File, error) {
+	master, slaveName, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+
+	slave, err := os.OpenFile(slaveName, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	defer slave.Close()
+
+	setTerminalSize(master, cols, rows)
+
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	return master, nil
+}
+
+// notifyResize calls resize when the terminal is resized. it returns stop function.
+func notifyResize(resize func()) func() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+	go func() {
+		for range ch {
+			resize()
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+)
+
+const (
+	DEFAULT_RDP_USER = "Administrator"
+	RDP_DIR_NAME     = "rdp"
+
+	// default RDP client command on MacOS (Microsoft Remote Desktop opens .rdp file)
+	DARWIN_RDP_COMMAND = "open {{.RdpFile}}"
+)
+
+// values for rdp_command. ex: open {{.RdpFile}}
+type RdpCommandValues struct {
+	RdpFile string
+	Host    string
+	User    string
+}
+
+func RdpCommandCheck(c string) error {
+	if _, err := parseRdpCommand(c); err != nil {
+		return fmt.Errorf("invalid RdpCommand value: %s", err.Error())
+	}
+
+	return nil
+}
+
+// each field of command is template, so the values that include space are kept as one arg.
+func parseRdpCommand(c string) ([]*template.Template, error) {
+	fields := strings.Fields(c)
+	templates := make([]*template.Template, 0, len(fields))
+	for _, f := range fields {
+		t, err := template.New("rdp_command").Option("missingkey=error").Parse(f)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, nil
+}
+
+func genRdpCommand(c string, v RdpCommandValues) ([]string, error) {
+	templates, err := parseRdpCommand(c)
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, len(templates))
+	for _, t := range templates {
+		var b bytes.Buffer
+		if err := t.Execute(&b, v); err != nil {
+			return nil, err
+		}
+		args = append(args, b.String())
+	}
+
+	return args, nil
+}
+
+// ConnectRdp shows administrator password of windows instance and starts RDP client.
+func ConnectRdp(rOpt *RnsshOption, e *ChoosableEC2, rdpUser string, handler *EC2Handler, rnsshDir string, out io.Writer, run CommandRunner, showCommand bool) error {
+	if e.TargetType == HOST_TYPE_SSM {
+		return fmt.Errorf("%s is windows instance. RDP via ssm host type is not supported", e.InstanceId)
+	}
+
+	if rdpUser == "" {
+		rdpUser = DEFAULT_RDP_USER
+	}
+
+	rdpFile := filepath.Join(rnsshDir, RDP_DIR_NAME, e.InstanceId+".rdp")
+	rdpCommand := rOpt.RdpCommand
+	if rdpCommand == "" && runtime.GOOS == "darwin" {
+		rdpCommand = DARWIN_RDP_COMMAND
+	}
+
+	cmdArgs, err := genRdpCommand(rdpCommand, RdpCommandValues{RdpFile: rdpFile, Host: e.Value(), User: rdpUser})
+	if err != nil {
+		return err
+	}
+
+	if showCommand {
+		fmt.Fprintf(out, "%s\n", strings.Join(cmdArgs, " "))
+		return nil
+	}
+
+	keyFile, err := ResolveIdentityFile(e, rOpt.IdentityFile, rOpt.IdentityFileTemplate, rOpt.IdentityFileByKeyName)
+	if err != nil {
+		return err
+	}
+
+	if keyFile == "" {
+		return fmt.Errorf("%s is windows instance. need identity file (private key of key pair %s) for decrypting password. please specify -i option", e.InstanceId, e.KeyName)
+	}
+
+	passwordData, err := handler.GetPasswordData(rOpt.Region, e.InstanceId)
+	if err != nil {
+		return fmt.Errorf("failed get password data: %s", err.Error())
+	}
+
+	if passwordData == "" {
+		return fmt.Errorf("password of %s is not available yet. please wait a few minutes after launch", e.InstanceId)
+	}
+
+	password, err := DecryptPasswordData(passwordData, keyFile)
+	if err != nil {
+		return err
+	}
+
+	if rOpt.CopyPassword {
+		if err := copyToClipboard(password); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "copied %s password to clipboard.\n", rdpUser)
+	} else {
+		fmt.Fprintf(out, "%s password: %s\n", rdpUser, password)
+	}
+
+	if err := writeRdpFile(rdpFile, e.Value(), rdpUser); err != nil {
+		return fmt.Errorf("failed write rdp file: %s", err.Error())
+	}
+
+	if len(cmdArgs) == 0 {
+		fmt.Fprintf(out, "saved rdp file: %s\n", rdpFile)
+		return nil
+	}
+
+	return run(cmdArgs[0], cmdArgs[1:]...)
+}
+
+// DecryptPasswordData decrypts password data with RSA private key (PEM) of the key pair.
+func DecryptPasswordData(passwordData, keyFile string) (string, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(strings.TrimSpace(passwordData))
+	if err != nil {
+		return "", fmt.Errorf("invalid password data: %s", err.Error())
+	}
+
+	path, err := expandHomeDir(keyFile)
+	if err != nil {
+		return "", err
+	}
+
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := parseRsaPrivateKey(pemBytes)
+	if err != nil {
+		return "", fmt.Errorf("can not use %s for decrypting password: %s", keyFile, err.Error())
+	}
+
+	decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, key, encrypted)
+	if err != nil {
+		return "", fmt.Errorf("failed decrypt password with %s: %s", keyFile, err.Error())
+	}
+
+	return string(decrypted), nil
+}
+
+func parseRsaPrivateKey(pemBytes []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("not PEM format")
+	}
+
+	if k, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return k, nil
+	}
+
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("not RSA private key (passphrase protected key is not supported)")
+	}
+
+	rsaKey, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not RSA private key")
+	}
+
+	return rsaKey, nil
+}
+
+func writeRdpFile(path, host, user string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("full address:s:%s\r\nusername:s:%s\r\nprompt for credentials:i:1\r\n", host, user)
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+func copyToClipboard(s string) error {
+	candidates := [][]string{
+		{"pbcopy"},
+		{"wl-copy"},
+		{"xclip", "-selection", "clipboard"},
+	}
+
+	for _, c := range candidates {
+		if _, err := exec.LookPath(c[0]); err != nil {
+			continue
+		}
+
+		cmd := exec.Command(c[0], c[1:]...)
+		cmd.Stdin = strings.NewReader(s)
+		return cmd.Run()
+	}
+
+	return fmt.Errorf("clipboard command is not found (pbcopy, wl-copy or xclip)")
+}
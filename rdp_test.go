@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRsaKey writes RSA private key (PKCS#1 PEM) and returns the key and the file path.
+func testRsaKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "win.pem")
+	content := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return key, path
+}
+
+// testPasswordData returns password encrypted with the key like EC2 GetPasswordData.
+func testPasswordData(t *testing.T, key *rsa.PrivateKey, password string) string {
+	t.Helper()
+
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(encrypted)
+}
+
+func TestParseRsaPrivateKey(t *testing.T) {
+	key, _ := testRsaKey(t)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPkcs8, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		pem  []byte
+		err  string
+	}{
+		{
+			name: "PKCS#1",
+			pem:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+		{
+			name: "PKCS#8",
+			pem:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		{
+			name: "ECDSA",
+			pem:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecPkcs8}),
+			err:  "not RSA private key",
+		},
+		{
+			name: "broken",
+			pem:  pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("broken")}),
+			err:  "passphrase protected key is not supported",
+		},
+		{
+			name: "not PEM",
+			pem:  []byte("ssh-rsa AAAA"),
+			err:  "not PEM format",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			k, err := parseRsaPrivateKey(c.pem)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("expected error %q but %v", c.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !k.Equal(key) {
+				t.Errorf("expected the same key")
+			}
+		})
+	}
+}
+
+func TestDecryptPasswordData(t *testing.T) {
+	key, keyFile := testRsaKey(t)
+	_, otherKeyFile := testRsaKey(t)
+
+	cases := []struct {
+		name         string
+		passwordData string
+		keyFile      string
+		expected     string
+		err          string
+	}{
+		{
+			name:         "decrypt",
+			passwordData: testPasswordData(t, key, "P@ssw0rd!"),
+			keyFile:      keyFile,
+			expected:     "P@ssw0rd!",
+		},
+		{
+			name:         "with new lines",
+			passwordData: "\n" + testPasswordData(t, key, "P@ssw0rd!") + "\n",
+			keyFile:      keyFile,
+			expected:     "P@ssw0rd!",
+		},
+		{
+			name:         "other key",
+			passwordData: testPasswordData(t, key, "P@ssw0rd!"),
+			keyFile:      otherKeyFile,
+			err:          "failed decrypt password with " + otherKeyFile,
+		},
+		{
+			name:         "invalid password data",
+			passwordData: "not base64!",
+			keyFile:      keyFile,
+			err:          "invalid password data",
+		},
+		{
+			name:         "no key file",
+			passwordData: testPasswordData(t, key, "P@ssw0rd!"),
+			keyFile:      filepath.Join(t.TempDir(), "none.pem"),
+			err:          "no such file",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			password, err := DecryptPasswordData(c.passwordData, c.keyFile)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("expected error %q but %v", c.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if password != c.expected {
+				t.Errorf("expected %q but %q", c.expected, password)
+			}
+		})
+	}
+}
+
+func TestGenRdpCommand(t *testing.T) {
+	v := RdpCommandValues{RdpFile: "/home/me/.rnssh/rdp/i-0001.rdp", Host: "203.0.113.1", User: "Administrator"}
+
+	cases := []struct {
+		command  string
+		expected []string
+		err      bool
+	}{
+		{command: DARWIN_RDP_COMMAND, expected: []string{"open", "/home/me/.rnssh/rdp/i-0001.rdp"}},
+		{command: "xfreerdp /v:{{.Host}} /u:{{.User}}", expected: []string{"xfreerdp", "/v:203.0.113.1", "/u:Administrator"}},
+		{command: "", expected: []string{}},
+		{command: "open {{.NoSuchValue}}", err: true},
+		{command: "open {{.RdpFile", err: true},
+	}
+
+	for _, c := range cases {
+		args, err := genRdpCommand(c.command, v)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected error but %v", c.command, args)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.command, err)
+		}
+
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("%q: expected %v but %v", c.command, c.expected, args)
+		}
+	}
+
+	if err := RdpCommandCheck("open {{.RdpFile"); err == nil || !strings.Contains(err.Error(), "invalid RdpCommand value") {
+		t.Errorf("expected invalid RdpCommand but %v", err)
+	}
+}
+
+func TestWriteRdpFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), RDP_DIR_NAME, "i-0001.rdp")
+	if err := writeRdpFile(path, "203.0.113.1", "Admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "full address:s:203.0.113.1\r\nusername:s:Admin\r\nprompt for credentials:i:1\r\n"
+	if string(content) != expected {
+		t.Errorf("expected %q but %q", expected, content)
+	}
+}
+
+func TestAppRunWindows(t *testing.T) {
+	key, keyFile := testRsaKey(t)
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n  rdp_command = \"xfreerdp /v:{{.Host}} /u:{{.User}} {{.RdpFile}}\"\n"
+
+	cases := []struct {
+		name     string
+		args     []string
+		code     int
+		contains string
+		rdpArgs  []string
+	}{
+		{
+			name:     "default user",
+			args:     []string{"-i", keyFile, "win"},
+			contains: "Administrator password: P@ssw0rd!\n",
+			rdpArgs:  []string{"/v:203.0.113.9", "/u:Administrator"},
+		},
+		{
+			name:     "user by user@",
+			args:     []string{"-i", keyFile, "rdpuser@win"},
+			contains: "rdpuser password: P@ssw0rd!\n",
+			rdpArgs:  []string{"/v:203.0.113.9", "/u:rdpuser"},
+		},
+		{
+			name:     "show command",
+			args:     []string{"-s", "win"},
+			contains: "xfreerdp /v:203.0.113.9 /u:Administrator",
+		},
+		{
+			name:     "without identity file",
+			args:     []string{"win"},
+			code:     1,
+			contains: "need identity file (private key of key pair win-key)",
+		},
+		{
+			name:     "remote command",
+			args:     []string{"exec", "win", "--", "uptime"},
+			code:     1,
+			contains: "can not run command on windows instance i-0009 (win1)",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newTestApp(t, "win1", config, newFakeInstance("i-0009", "win1", withPublicIP("203.0.113.9"), withWindows("win-key")))
+			a.EC2.PasswordData = map[string]string{"i-0009": testPasswordData(t, key, "P@ssw0rd!")}
+
+			if code := a.Run(c.args); code != c.code {
+				t.Fatalf("expected exit code %d but %d: %s", c.code, code, a.Out.String())
+			}
+
+			if !strings.Contains(a.Out.String(), c.contains) {
+				t.Errorf("expected output contains %q but %q", c.contains, a.Out.String())
+			}
+
+			if c.rdpArgs == nil {
+				if a.Recorder.Name != "" {
+					t.Errorf("expected RDP client is not started but %s %v", a.Recorder.Name, a.Recorder.Args)
+				}
+				return
+			}
+
+			rdpFile := filepath.Join(a.RnsshDir, RDP_DIR_NAME, "i-0009.rdp")
+			if a.Recorder.Name != "xfreerdp" || !reflect.DeepEqual(a.Recorder.Args, append(c.rdpArgs, rdpFile)) {
+				t.Errorf("expected xfreerdp %v but %s %v", c.rdpArgs, a.Recorder.Name, a.Recorder.Args)
+			}
+
+			if content, err := os.ReadFile(rdpFile); err != nil || !strings.Contains(string(content), "full address:s:203.0.113.9") {
+				t.Errorf("unexpected rdp file: %q %v", content, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/reiki4040/peco"
+)
+
+const (
+	SELECTOR_PECO    = "peco"
+	SELECTOR_FZF     = "fzf"
+	SELECTOR_SKIM    = "sk"
+	SELECTOR_BUILTIN = "builtin"
+)
+
+// Selector chooses items interactively.
+type Selector interface {
+	Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error)
+}
+
+func SelectorCheck(s string) error {
+	switch s {
+	case SELECTOR_PECO:
+		fallthrough
+	case SELECTOR_FZF:
+		fallthrough
+	case SELECTOR_SKIM:
+		fallthrough
+	case SELECTOR_BUILTIN:
+		fallthrough
+	case "":
+		return nil
+	default:
+		return fmt.Errorf("invalid Selector value: %s. allow peco, fzf, sk, builtin or \"\"(default peco)", s)
+	}
+}
+
+// Previewer describes highlighted item in preview pane. peco does not support preview.
+type Previewer struct {
+	// preview command for external finder. ex: rnssh -describe {1} -r ap-northeast-1
+	Command string
+
+	// details for builtin selector.
+	Describe func(c peco.Choosable) string
+}
+
+// NewSelector returns selector by name. options are passed to external finder (fzf, sk).
+// preview can be nil.
+func NewSelector(name string, options []string, preview *Previewer, in io.Reader, out io.Writer) Selector {
+	switch name {
+	case SELECTOR_FZF, SELECTOR_SKIM:
+		s := &ExternalSelector{Command: name, Options: options}
+		if preview != nil {
+			s.PreviewCommand = preview.Command
+		}
+		return s
+	case SELECTOR_BUILTIN:
+		s := &BuiltinSelector{In: in, Out: out}
+		if preview != nil {
+			s.Describe = preview.Describe
+		}
+		return s
+	default:
+		return &PecoSelector{}
+	}
+}
+
+// PecoSelector chooses with built-in peco.
+type PecoSelector struct{}
+
+func (s *PecoSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	return peco.Choose(itemName, message, defaultQuery, choices)
+}
+
+// ExternalSelector chooses with external fuzzy finder (fzf, sk).
+// it pipes Choice() lines to the finder and maps selected lines back to Choosable.
+type ExternalSelector struct {
+	Command        string
+	Options        []string
+	PreviewCommand string
+}
+
+func (s *ExternalSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("there is no %s.", itemName)
+	}
+
+	if _, err := exec.LookPath(s.Command); err != nil {
+		return nil, fmt.Errorf("%s is not found. please install it or use other selector (-selector builtin)", s.Command)
+	}
+
+	choiceMap := make(map[string]peco.Choosable, len(choices))
+	var lines bytes.Buffer
+	for _, c := range choices {
+		choiceMap[c.Choice()] = c
+		lines.WriteString(c.Choice() + "\n")
+	}
+
+	args := []string{"--multi", "--prompt", message + " >", "--query", defaultQuery}
+	if s.PreviewCommand != "" {
+		args = append(args, "--preview", s.PreviewCommand)
+	}
+	args = append(args, s.Options...)
+
+	// finder draws to tty, so only stdout is captured.
+	cmd := exec.Command(s.Command, args...)
+	cmd.Stdin = &lines
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			// no match or canceled
+			return nil, fmt.Errorf("no select %s.", itemName)
+		}
+		return nil, err
+	}
+
+	chosen := make([]peco.Choosable, 0)
+	for _, l := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+		if c, ok := choiceMap[l]; ok {
+			chosen = append(chosen, c)
+		}
+	}
+
+	if len(chosen) == 0 {
+		return nil, fmt.Errorf("no select %s.", itemName)
+	}
+
+	return chosen, nil
+}
+
+// BuiltinSelector is minimal line based selector for environments without fuzzy finder.
+// input number(s) to choose, ?number to show details, other input is new query.
+type BuiltinSelector struct {
+	In       io.Reader
+	Out      io.Writer
+	Describe func(c peco.Choosable) string
+}
+
+func (s *BuiltinSelector) Choose(itemName, message, defaultQuery string, choices []peco.Choosable) ([]peco.Choosable, error) {
+	if len(choices) == 0 {
+		return nil, fmt.Errorf("there is no %s.", itemName)
+	}
+
+	r := bufio.NewReader(s.In)
+	query := defaultQuery
+	for {
+		matched := MatchChoices(query, choices)
+		for i, c := range matched {
+			fmt.Fprintf(s.Out, "%3d: %s\n", i+1, c.Choice())
+		}
+
+		if s.Describe != nil {
+			fmt.Fprintf(s.Out, "%s (query: %s) [number(s) / ?number for details / new query / empty]>", message, query)
+		} else {
+			fmt.Fprintf(s.Out, "%s (query: %s) [number(s) / new query / empty]>", message, query)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("no select %s.", itemName)
+		}
+
+		input := strings.TrimSpace(line)
+		if input == "" {
+			// choose the only one
+			if len(matched) == 1 {
+				return matched, nil
+			}
+			return nil, fmt.Errorf("no select %s.", itemName)
+		}
+
+		if s.Describe != nil && strings.HasPrefix(input, "?") {
+			if chosen, ok := chooseByNumbers(input[1:], matched); ok && len(chosen) == 1 {
+				fmt.Fprintf(s.Out, "\n%s\n", s.Describe(chosen[0]))
+				continue
+			}
+		}
+
+		if chosen, ok := chooseByNumbers(input, matched); ok {
+			return chosen, nil
+		}
+
+		query = input
+	}
+}
+
+func chooseByNumbers(input string, matched []peco.Choosable) ([]peco.Choosable, bool) {
+	chosen := make([]peco.Choosable, 0)
+	for _, f := range strings.Fields(input) {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 || n > len(matched) {
+			return nil, false
+		}
+		chosen = append(chosen, matched[n-1])
+	}
+
+	return chosen, true
+}
+
+// MatchChoices returns choices that include all words of query. (ignore case)
+func MatchChoices(query string, choices []peco.Choosable) []peco.Choosable {
+	words := strings.Fields(strings.ToLower(query))
+	matched := make([]peco.Choosable, 0, len(choices))
+	for _, c := range choices {
+		line := strings.ToLower(c.Choice())
+		ok := true
+		for _, w := range words {
+			if !strings.Contains(line, w) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			matched = append(matched, c)
+		}
+	}
+
+	return matched
+}
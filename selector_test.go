@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/reiki4040/peco"
+)
+
+var selectorTestChoices = []peco.Choosable{
+	&peco.Choice{C: "i-0001    web1    203.0.113.1", V: "203.0.113.1"},
+	&peco.Choice{C: "i-0002    web2    203.0.113.2", V: "203.0.113.2"},
+	&peco.Choice{C: "i-0003    db1     10.0.0.3", V: "10.0.0.3"},
+}
+
+func chosenValues(chosen []peco.Choosable) []string {
+	values := make([]string, 0, len(chosen))
+	for _, c := range chosen {
+		values = append(values, c.Value())
+	}
+
+	return values
+}
+
+func TestMatchChoices(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"203.0.113.1", "203.0.113.2", "10.0.0.3"}},
+		{"web", []string{"203.0.113.1", "203.0.113.2"}},
+		{"WEB i-0002", []string{"203.0.113.2"}},
+		{"app", []string{}},
+	}
+
+	for _, tt := range tests {
+		actual := chosenValues(MatchChoices(tt.query, selectorTestChoices))
+		if !reflect.DeepEqual(actual, tt.expected) {
+			t.Errorf("query %q: expected %v but %v", tt.query, tt.expected, actual)
+		}
+	}
+}
+
+func TestBuiltinSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		input    string
+		wantErr  bool
+		expected []string
+	}{
+		{name: "number", input: "2\n", expected: []string{"203.0.113.2"}},
+		{name: "numbers", input: "1 3\n", expected: []string{"203.0.113.1", "10.0.0.3"}},
+		{name: "number in filtered", query: "web", input: "2\n", expected: []string{"203.0.113.2"}},
+		{name: "new query and only one", input: "db\n\n", expected: []string{"10.0.0.3"}},
+		{name: "empty with multiple matched", input: "\n", wantErr: true},
+		{name: "EOF", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &BuiltinSelector{In: strings.NewReader(tt.input), Out: &bytes.Buffer{}}
+			chosen, err := s.Choose("server", "which servers?", tt.query, selectorTestChoices)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error but nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if actual := chosenValues(chosen); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v but %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExternalSelector(t *testing.T) {
+	// fake finder that selects the second line.
+	dir := t.TempDir()
+	script := "#!/bin/sh\nsed -n 2p\n"
+	if err := os.WriteFile(filepath.Join(dir, "fzf"), []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	s := NewSelector(SELECTOR_FZF, []string{"--no-sort"}, nil, nil, nil)
+	chosen, err := s.Choose("server", "which servers?", "", selectorTestChoices)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if actual := chosenValues(chosen); !reflect.DeepEqual(actual, []string{"203.0.113.2"}) {
+		t.Errorf("expected [203.0.113.2] but %v", actual)
+	}
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	SESSIONS_DIR_NAME = "sessions"
+
+	// asciicast v2 file. (https://docs.asciinema.org/manual/asciicast/v2/)
+	SESSION_FILE_EXT = ".cast"
+
+	// recording session. it is renamed to .cast when the session ends.
+	SESSION_PART_EXT = ".part"
+
+	ASCIICAST_VERSION = 2
+
+	DEFAULT_TERMINAL_COLS = 80
+	DEFAULT_TERMINAL_ROWS = 24
+
+	// long idle is shortened when play.
+	SESSION_PLAY_IDLE_LIMIT = 2 * time.Second
+)
+
+// SessionMeta is tag of recorded session.
+type SessionMeta struct {
+	InstanceId string    `json:"instance_id,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Host       string    `json:"host"`
+	User       string    `json:"user,omitempty"`
+	Profile    string    `json:"profile,omitempty"`
+	Region     string    `json:"region,omitempty"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+}
+
+// asciicast v2 header. rnssh is extra field of rnssh tag.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Duration  float64           `json:"duration,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Rnssh     *SessionMeta      `json:"rnssh,omitempty"`
+}
+
+func sessionsDir(rnsshDir string) string {
+	return filepath.Join(rnsshDir, SESSIONS_DIR_NAME)
+}
+
+// sessionPath returns file path of the session. ex: ~/.rnssh/sessions/20261019-123456-i-0123456789abcdef0.cast
+func sessionPath(rnsshDir string, meta *SessionMeta) string {
+	id := meta.InstanceId
+	if id == "" {
+		id = meta.Host
+	}
+
+	// host can be ssh config name that has any char.
+	id = strings.Map(func(r rune) rune {
+		if r == '/' || r == os.PathSeparator || r == ' ' {
+			return '_'
+		}
+		return r
+	}, id)
+
+	return filepath.Join(sessionsDir(rnsshDir), meta.StartTime.Format("20060102-150405")+"-"+id+SESSION_FILE_EXT)
+}
+
+func sessionTitle(meta *SessionMeta) string {
+	host := meta.Host
+	if meta.User != "" {
+		host = meta.User + "@" + host
+	}
+
+	if meta.InstanceId != "" {
+		host += " (" + meta.InstanceId + ")"
+	}
+
+	return "rnssh " + host
+}
+
+// castWriter writes asciicast output events.
+type castWriter struct {
+	mu    sync.Mutex
+	w     *bufio.Writer
+	start time.Time
+
+	// incomplete UTF-8 sequence of previous output.
+	rest []byte
+}
+
+func (c *castWriter) output(b []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b = append(c.rest, b...)
+	n := len(b)
+	for i := 1; i <= utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				n = len(b) - i
+			}
+			break
+		}
+	}
+	c.rest = append([]byte(nil), b[n:]...)
+
+	if n == 0 {
+		return nil
+	}
+
+	return c.event("o", string(b[:n]))
+}
+
+func (c *castWriter) event(code, data string) error {
+	e, err := json.Marshal([]interface{}{time.Since(c.start).Seconds(), code, data})
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.w.Write(append(e, '\n')); err != nil {
+		return err
+	}
+
+	return c.w.Flush()
+}
+
+// RecordSession runs command with pseudo terminal and records its output to asciicast v2 file.
+// the file is <path>.part while recording. input is not recorded. (passwords)
+func RecordSession(path string, meta *SessionMeta, stdin io.Reader, stdout io.Writer, name string, args ...string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	cols, rows := DEFAULT_TERMINAL_COLS, DEFAULT_TERMINAL_ROWS
+	in, inIsFile := stdin.(*os.File)
+	inIsTerminal := inIsFile && isTerminal(in)
+	if inIsTerminal {
+		if c, r, err := terminalSize(in); err == nil && c > 0 && r > 0 {
+			cols, rows = c, r
+		}
+	}
+
+	part := path + SESSION_PART_EXT
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := &castHeader{
+		Version:   ASCIICAST_VERSION,
+		Width:     cols,
+		Height:    rows,
+		Timestamp: meta.StartTime.Unix(),
+		Title:     sessionTitle(meta),
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+		Rnssh:     meta,
+	}
+
+	cw := &castWriter{w: bufio.NewWriter(f), start: meta.StartTime}
+	if err := json.NewEncoder(cw.w).Encode(header); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(name, args...)
+	master, err := startPty(cmd, cols, rows)
+	if err != nil {
+		return fmt.Errorf("can not start session recording: %s", err.Error())
+	}
+	defer master.Close()
+
+	if inIsTerminal {
+		restore, err := makeRaw(in)
+		if err == nil {
+			defer restore()
+		}
+
+		stop := notifyResize(func() {
+			if c, r, err := terminalSize(in); err == nil {
+				setTerminalSize(master, c, r)
+			}
+		})
+		defer stop()
+	}
+
+	// stdin is copied until rnssh exits.
+	go io.Copy(master, stdin)
+
+	buf := make([]byte, 32*1024)
+	var recordErr error
+	for {
+		n, err := master.Read(buf)
+		if n > 0 {
+			stdout.Write(buf[:n])
+			if err := cw.output(buf[:n]); err != nil && recordErr == nil {
+				recordErr = err
+			}
+		}
+
+		// EIO after the command exits on linux.
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) && recordErr == nil {
+				recordErr = err
+			}
+			break
+		}
+	}
+
+	cmdErr := cmd.Wait()
+	meta.EndTime = time.Now()
+	header.Duration = meta.EndTime.Sub(meta.StartTime).Seconds()
+
+	if recordErr == nil {
+		recordErr = finishSession(part, path, header)
+	}
+
+	if recordErr != nil {
+		return fmt.Errorf("session recording failed (%s is kept): %s", part, recordErr.Error())
+	}
+
+	return cmdErr
+}
+
+// finishSession rewrites header with end time and renames part file.
+func finishSession(part, path string, header *castHeader) error {
+	content, err := os.ReadFile(part)
+	if err != nil {
+		return err
+	}
+
+	// first line is header
+	if i := strings.IndexByte(string(content), '\n'); i >= 0 {
+		content = content[i+1:]
+	}
+
+	err = writeFileAtomic(path, func(w io.Writer) error {
+		if err := json.NewEncoder(w).Encode(header); err != nil {
+			return err
+		}
+
+		_, err := w.Write(content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(part)
+}
+
+// SessionInfo is recorded session file.
+type SessionInfo struct {
+	Path     string
+	Header   *castHeader
+	Duration time.Duration
+
+	// recording or rnssh is terminated.
+	Incomplete bool
+}
+
+func readCastHeader(path string) (*castHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+
+	h := &castHeader{}
+	if err := json.Unmarshal(line, h); err != nil {
+		return nil, fmt.Errorf("%s: invalid asciicast header: %s", path, err.Error())
+	}
+
+	if h.Version != ASCIICAST_VERSION {
+		return nil, fmt.Errorf("%s: unsupported asciicast version %d", path, h.Version)
+	}
+
+	return h, nil
+}
+
+// ListSessions returns recorded sessions. (older first)
+func ListSessions(rnsshDir string) ([]*SessionInfo, error) {
+	dir := sessionsDir(rnsshDir)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*SessionInfo, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		incomplete := strings.HasSuffix(name, SESSION_FILE_EXT+SESSION_PART_EXT)
+		if e.IsDir() || (!strings.HasSuffix(name, SESSION_FILE_EXT) && !incomplete) {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		h, err := readCastHeader(path)
+		if err != nil {
+			return nil, err
+		}
+
+		info := &SessionInfo{Path: path, Header: h, Incomplete: incomplete}
+		info.Duration = time.Duration(h.Duration * float64(time.Second)).Round(time.Second)
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Path < infos[j].Path
+	})
+
+	return infos, nil
+}
+
+func WriteSessionList(w io.Writer, infos []*SessionInfo) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tDURATION\tINSTANCE\tNAME\tUSER\tHOST\tPROFILE\tFILE")
+	for _, s := range infos {
+		m := s.Header.Rnssh
+		if m == nil {
+			m = &SessionMeta{StartTime: time.Unix(s.Header.Timestamp, 0)}
+		}
+
+		duration := s.Duration.String()
+		if s.Incomplete {
+			duration = "(incomplete)"
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.StartTime.Local().Format(time.RFC3339), duration,
+			orDash(m.InstanceId), orDash(m.Name), orDash(m.User), orDash(m.Host), orDash(m.Profile), filepath.Base(s.Path))
+	}
+	tw.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
+
+// findSession returns session path by file path or file name in sessions dir.
+func findSession(rnsshDir, name string) (string, error) {
+	for _, path := range []string{name, filepath.Join(sessionsDir(rnsshDir), name)} {
+		if st, err := os.Stat(path); err == nil && !st.IsDir() {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("session is not found: %s. see rnssh sessions ls", name)
+}
+
+// PlaySession writes output of recorded session with timing. speed 2 is twice as fast.
+// long idle is shortened to SESSION_PLAY_IDLE_LIMIT.
+func PlaySession(w io.Writer, path string, speed float64, sleep func(time.Duration)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if speed <= 0 {
+		speed = 1
+	}
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !s.Scan() {
+		return fmt.Errorf("%s: empty session", path)
+	}
+
+	h := &castHeader{}
+	if err := json.Unmarshal(s.Bytes(), h); err != nil || h.Version != ASCIICAST_VERSION {
+		return fmt.Errorf("%s: not asciicast v2 file", path)
+	}
+
+	prev := 0.0
+	for line := 2; s.Scan(); line++ {
+		var e []interface{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil || len(e) != 3 {
+			return fmt.Errorf("%s:%d: invalid event", path, line)
+		}
+
+		t, ok1 := e[0].(float64)
+		code, ok2 := e[1].(string)
+		data, ok3 := e[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("%s:%d: invalid event", path, line)
+		}
+
+		if code != "o" {
+			continue
+		}
+
+		wait := time.Duration((t - prev) / speed * float64(time.Second))
+		if wait > SESSION_PLAY_IDLE_LIMIT {
+			wait = SESSION_PLAY_IDLE_LIMIT
+		}
+		if wait > 0 {
+			sleep(wait)
+		}
+		prev = t
+
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCastWriterSplitUTF8(t *testing.T) {
+	var b bytes.Buffer
+	cw := &castWriter{w: bufio.NewWriter(&b), start: time.Now()}
+
+	for _, out := range [][]byte{[]byte("a\xe3\x81"), []byte("\x82b")} {
+		if err := cw.output(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var e []interface{}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, e[2].(string))
+	}
+
+	if !reflect.DeepEqual(data, []string{"a", "あb"}) {
+		t.Errorf("expected UTF-8 is not split but %q", data)
+	}
+}
+
+func TestRecordSession(t *testing.T) {
+	if m, _, err := openPty(); err != nil {
+		t.Skipf("pseudo terminal is not available: %v", err)
+	} else {
+		m.Close()
+	}
+
+	start := time.Now()
+	meta := &SessionMeta{InstanceId: "i-0001", Name: "web1", Host: "203.0.113.1", User: "ec2-user", Profile: "prod", StartTime: start}
+	path := sessionPath(t.TempDir(), meta)
+
+	var out bytes.Buffer
+	if err := RecordSession(path, meta, strings.NewReader(""), &out, "sh", "-c", "printf 'hello\\n'; test -t 0"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !strings.Contains(out.String(), "hello") {
+		t.Errorf("expected output is shown but %q", out.String())
+	}
+
+	if _, err := os.Stat(path + SESSION_PART_EXT); !os.IsNotExist(err) {
+		t.Errorf("expected part file is removed but %v", err)
+	}
+
+	h, err := readCastHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.Rnssh.InstanceId != "i-0001" || h.Rnssh.User != "ec2-user" || h.Rnssh.Profile != "prod" || h.Rnssh.EndTime.Before(start) || h.Title != "rnssh ec2-user@203.0.113.1 (i-0001)" {
+		t.Errorf("unexpected header: %+v %+v", h, h.Rnssh)
+	}
+
+	var played bytes.Buffer
+	if err := PlaySession(&played, path, 1, func(time.Duration) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	if played.String() != out.String() {
+		t.Errorf("expected played output %q but %q", out.String(), played.String())
+	}
+
+	// exit status of command is returned.
+	meta.StartTime = start.Add(time.Second)
+	if err := RecordSession(sessionPath(t.TempDir(), meta), meta, strings.NewReader(""), &out, "sh", "-c", "exit 3"); err == nil {
+		t.Errorf("expected exit error")
+	}
+}
+
+func TestPlaySession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.cast")
+	content := `{"version": 2, "width": 80, "height": 24, "timestamp": 1760000000}
+[0.5, "o", "a"]
+[1.5, "i", "secret"]
+[1.5, "o", "b"]
+[100.0, "o", "c"]
+`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	waits := make([]time.Duration, 0)
+	if err := PlaySession(&out, path, 2, func(d time.Duration) { waits = append(waits, d) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "abc" {
+		t.Errorf("expected output only but %q", out.String())
+	}
+
+	expected := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, SESSION_PLAY_IDLE_LIMIT}
+	if !reflect.DeepEqual(waits, expected) {
+		t.Errorf("expected waits %v but %v", expected, waits)
+	}
+}
+
+func TestAppRunSessionRecording(t *testing.T) {
+	if m, _, err := openPty(); err != nil {
+		t.Skipf("pseudo terminal is not available: %v", err)
+	} else {
+		m.Close()
+	}
+
+	// fake ssh prints args.
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ssh"), []byte("#!/bin/sh\necho \"fake ssh $*\"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config := "[Default]\n  aws_region = \"ap-northeast-1\"\n\n[[profiles]]\n  profile_name = \"prod\"\n  session_recording = true\n"
+	a := newTestApp(t, "web1", config, newFakeInstance("i-0001", "web1", withPublicIP("203.0.113.1")))
+
+	if code := a.Run([]string{"-profile", "prod", "-l", "ec2-user"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "fake ssh -lec2-user 203.0.113.1") || !strings.Contains(a.Out.String(), "rnssh: recording session to "+sessionsDir(a.RnsshDir)) {
+		t.Errorf("unexpected output: %q", a.Out.String())
+	}
+
+	// not recorded without the profile.
+	a.Out.Reset()
+	if code := a.Run([]string{"-l", "ec2-user"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"sessions", "ls"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(a.Out.String()), "\n")
+	if len(lines) != 2 || !regexp.MustCompile(`^\S+\s+\S+\s+i-0001\s+web1\s+ec2-user\s+203\.0\.113\.1\s+prod\s+(\S+-i-0001\.cast)$`).MatchString(lines[1]) {
+		t.Fatalf("unexpected sessions: %q", a.Out.String())
+	}
+	name := strings.Fields(lines[1])[7]
+
+	a.Out.Reset()
+	if code := a.Run([]string{"sessions", "play", "-speed", "100", name}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if !strings.Contains(a.Out.String(), "fake ssh -lec2-user 203.0.113.1") {
+		t.Errorf("unexpected played output: %q", a.Out.String())
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-profile", "prod", "-tmux"}); code != 1 || !strings.Contains(a.Out.String(), "session recording does not support -tmux") {
+		t.Errorf("expected error of -tmux but %d: %q", code, a.Out.String())
+	}
+}
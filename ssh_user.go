@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	DEFAULT_SSH_USER_TAG = "SshUser"
+)
+
+type SshUserRule struct {
+	// lower case substring of AMI name or platform details.
+	Pattern string
+	User    string
+}
+
+// built-in default login user table. first matched rule wins.
+var DefaultSshUserRules = []SshUserRule{
+	{Pattern: "ubuntu", User: "ubuntu"},
+	{Pattern: "debian", User: "admin"},
+	{Pattern: "centos", User: "centos"},
+	{Pattern: "rocky", User: "rocky"},
+	{Pattern: "fedora", User: "fedora"},
+	{Pattern: "bitnami", User: "bitnami"},
+	{Pattern: "almalinux", User: "ec2-user"},
+	{Pattern: "suse", User: "ec2-user"},
+	{Pattern: "rhel", User: "ec2-user"},
+	{Pattern: "red hat", User: "ec2-user"},
+	{Pattern: "freebsd", User: "ec2-user"},
+	{Pattern: "amzn", User: "ec2-user"},
+	{Pattern: "al2023", User: "ec2-user"},
+	{Pattern: "amazon linux", User: "ec2-user"},
+}
+
+// DetectSshUser infers default login user of the instance.
+// priority [high] instance tag > config table > built-in table [low]
+func DetectSshUser(e *ChoosableEC2, userTag string, userByAMI map[string]string) string {
+	if userTag == "" {
+		userTag = DEFAULT_SSH_USER_TAG
+	}
+
+	if u := e.Tags[userTag]; u != "" {
+		return u
+	}
+
+	targets := []string{strings.ToLower(e.ImageName), strings.ToLower(e.PlatformDetails)}
+	for _, rule := range append(configSshUserRules(userByAMI), DefaultSshUserRules...) {
+		for _, t := range targets {
+			if t != "" && strings.Contains(t, rule.Pattern) {
+				return rule.User
+			}
+		}
+	}
+
+	return ""
+}
+
+// longer pattern is more specific, so it is checked first.
+func configSshUserRules(userByAMI map[string]string) []SshUserRule {
+	rules := make([]SshUserRule, 0, len(userByAMI))
+	for p, u := range userByAMI {
+		rules = append(rules, SshUserRule{Pattern: strings.ToLower(p), User: u})
+	}
+
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].Pattern) != len(rules[j].Pattern) {
+			return len(rules[i].Pattern) > len(rules[j].Pattern)
+		}
+
+		return rules[i].Pattern < rules[j].Pattern
+	})
+
+	return rules
+}
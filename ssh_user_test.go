@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDetectSshUser(t *testing.T) {
+	userByAMI := map[string]string{
+		"golden":        "deploy",
+		"golden-ubuntu": "app",
+		"Ubuntu":        "ubuntu-custom",
+	}
+
+	cases := []struct {
+		name      string
+		e         *ChoosableEC2
+		userTag   string
+		userByAMI map[string]string
+		expected  string
+	}{
+		{
+			name:      "tag is prior to config and built-in",
+			e:         &ChoosableEC2{Tags: map[string]string{"SshUser": "tagged"}, ImageName: "golden-ubuntu-2024"},
+			userByAMI: userByAMI,
+			expected:  "tagged",
+		},
+		{
+			name:     "custom tag key",
+			e:        &ChoosableEC2{Tags: map[string]string{"SshUser": "ignored", "LoginUser": "custom"}, ImageName: "ubuntu-jammy"},
+			userTag:  "LoginUser",
+			expected: "custom",
+		},
+		{
+			name:      "empty tag value falls back",
+			e:         &ChoosableEC2{Tags: map[string]string{"SshUser": ""}, ImageName: "golden-2024"},
+			userByAMI: userByAMI,
+			expected:  "deploy",
+		},
+		{
+			name:      "config is prior to built-in",
+			e:         &ChoosableEC2{ImageName: "ubuntu/images/hvm-ssd/ubuntu-jammy-22.04"},
+			userByAMI: userByAMI,
+			expected:  "ubuntu-custom",
+		},
+		{
+			name:      "longer config pattern first",
+			e:         &ChoosableEC2{ImageName: "golden-ubuntu-2024"},
+			userByAMI: userByAMI,
+			expected:  "app",
+		},
+		{
+			name:     "built-in by AMI name",
+			e:        &ChoosableEC2{ImageName: "debian-12-amd64-20240101"},
+			expected: "admin",
+		},
+		{
+			name:     "built-in by platform details",
+			e:        &ChoosableEC2{PlatformDetails: "Red Hat Enterprise Linux"},
+			expected: "ec2-user",
+		},
+		{
+			name:      "unknown",
+			e:         &ChoosableEC2{ImageName: "my-own-image", PlatformDetails: "Linux/UNIX"},
+			userByAMI: userByAMI,
+			expected:  "",
+		},
+		{
+			name:     "no AMI name",
+			e:        &ChoosableEC2{},
+			expected: "",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if u := DetectSshUser(c.e, c.userTag, c.userByAMI); u != c.expected {
+				t.Errorf("expected %q but %q", c.expected, u)
+			}
+		})
+	}
+}
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/reiki4040/peco"
+)
+
+const (
+	RESOURCE_TYPE_EC2        = "ec2"
+	RESOURCE_TYPE_SSH_CONFIG = "ssh_config"
+)
+
+// WizardAnswers are answers that are specified by options. empty (or -1) is asked in the wizard.
+type WizardAnswers struct {
+	// ec2 or ssh_config
+	ResourceType string
+
+	Region                  string
+	HostType                string
+	SshUser                 string
+	IdentityFile            string
+	Port                    int
+	StrictHostKeyCheckingNo int
+
+	// save to the profile. empty is Default.
+	Profile string
+
+	// use default answers instead of asking. (for headless setup)
+	NoPrompt bool
+}
+
+// NewWizardAnswers converts -init options to answers.
+func NewWizardAnswers(opt *CommandOption) *WizardAnswers {
+	ans := &WizardAnswers{
+		Region:                  opt.Region,
+		HostType:                opt.HostType,
+		SshUser:                 opt.SshUser,
+		IdentityFile:            opt.IdentityFile,
+		Port:                    opt.Port,
+		StrictHostKeyCheckingNo: opt.StrictHostKeyCheckingNo,
+		Profile:                 opt.Profile,
+		NoPrompt:                opt.NoPrompt,
+	}
+
+	if ans.HostType == "" {
+		ans.HostType = getSshTargetType(opt.PublicIP, opt.PrivateIP, opt.NameTag)
+	}
+
+	if opt.UseSshConfig {
+		ans.ResourceType = RESOURCE_TYPE_SSH_CONFIG
+	} else if opt.UseEC2 {
+		ans.ResourceType = RESOURCE_TYPE_EC2
+	}
+
+	return ans
+}
+
+// ConfigWizard asks rnssh config with selector, and text input (ssh user, port) from In.
+type ConfigWizard struct {
+	Selector Selector
+	In       io.Reader
+	Out      io.Writer
+
+	// region choices. nil is built-in list.
+	Regions func() []peco.Choosable
+
+	// identity file choices are found from it. (~/.ssh)
+	SshDir string
+
+	in *bufio.Reader
+}
+
+// DoConfigWizard asks settings and saves them to Default (or the profile) of the config file.
+func DoConfigWizard(path string, w *ConfigWizard, ans *WizardAnswers) error {
+	c, err := w.Ask(ans)
+	if err != nil {
+		return err
+	}
+
+	// keep profiles
+	conf, _, err := ReadConfigFile(path)
+	if os.IsNotExist(err) {
+		conf = &Config{}
+	} else if err != nil {
+		return err
+	}
+
+	// merge only the answered settings. others (ex: ssh_user_by_ami) are kept.
+	keys := wizardKeys(c)
+	if ans.Profile == "" || ans.Profile == conf.Default.Name {
+		overlayConfig(&conf.Default, c, keys)
+	} else {
+		c.Name = ans.Profile
+		merged := false
+		for i := range conf.Profiles {
+			if conf.Profiles[i].Name == ans.Profile {
+				overlayConfig(&conf.Profiles[i], c, keys)
+				merged = true
+			}
+		}
+
+		if !merged {
+			conf.Profiles = append(conf.Profiles, *c)
+		}
+	}
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	return SaveConfig(path, conf)
+}
+
+// wizardKeys returns config keys that the wizard answered. empty answer also overrides. (ex: no ssh user)
+// AWS settings are not answered if ssh config is used without them.
+func wizardKeys(c *RnsshConfig) map[string]bool {
+	keys := map[string]bool{
+		"use_ssh_config":                  true,
+		"ssh_strict_host_key_checking_no": true,
+		"ssh_user":                        true,
+		"ssh_identity_file":               true,
+		"ssh_port":                        true,
+	}
+
+	if !c.UseSshConfig || c.AWSRegion != "" {
+		keys["aws_region"] = true
+		keys["host_type"] = true
+	}
+
+	return keys
+}
+
+// Ask asks the settings that are not answered.
+func (w *ConfigWizard) Ask(ans *WizardAnswers) (*RnsshConfig, error) {
+	resourceType := ans.ResourceType
+	if resourceType == "" {
+		var err error
+		resourceType, err = w.choose(ans, "rnssh ResourceType option", "Please select resource type", ResourceTypeList, RESOURCE_TYPE_EC2)
+		if err != nil {
+			return nil, fmt.Errorf("ResourceType choose error:%s", err.Error())
+		}
+	}
+
+	c := &RnsshConfig{}
+	switch resourceType {
+	case RESOURCE_TYPE_EC2:
+		region, hostType, err := w.Ec2(ans)
+		if err != nil {
+			return nil, err
+		}
+		c.AWSRegion, c.HostType = region, hostType
+
+	case RESOURCE_TYPE_SSH_CONFIG:
+		// AWS settings are specified, then continue.
+		chosen := "no"
+		if ans.Region != "" || ans.HostType != "" {
+			chosen = "yes"
+		} else {
+			var err error
+			chosen, err = w.choose(ans, "next setting", "next, continue to AWS settings?", ContinueList, "no")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if chosen == "yes" {
+			region, hostType, err := w.Ec2(ans)
+			if err != nil {
+				return nil, err
+			}
+			c.AWSRegion, c.HostType = region, hostType
+		}
+
+		c.UseSshConfig = true
+
+	default:
+		return nil, fmt.Errorf("invalid resource type: %s. allow ec2 or ssh_config", resourceType)
+	}
+
+	var err error
+	if c.SshStrictHostKeyCheckingNo, err = w.StrictHostKeyChecking(ans); err != nil {
+		return nil, err
+	}
+
+	if c.SshUser, err = w.SshUser(ans); err != nil {
+		return nil, err
+	}
+
+	if c.SshIdentityFile, err = w.IdentityFile(ans); err != nil {
+		return nil, err
+	}
+
+	if c.SshPort, err = w.SshPort(ans); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (w *ConfigWizard) Ec2(ans *WizardAnswers) (string, string, error) {
+	region := ans.Region
+	if region == "" {
+		if ans.NoPrompt {
+			return "", "", fmt.Errorf("region is required with -no-prompt. please specify by region option (-r)")
+		}
+
+		regions := AWSRegionList
+		if w.Regions != nil {
+			regions = w.Regions()
+		}
+
+		var err error
+		region, err = w.choose(ans, "AWS region", "Please select default AWS region", regions, "")
+		if err != nil {
+			return "", "", fmt.Errorf("region choose error:%s", err.Error())
+		}
+	}
+
+	hostType := ans.HostType
+	if hostType == "" {
+		var err error
+		hostType, err = w.choose(ans, "rnssh host type", "Please select default host type", HostTypeList, HOST_TYPE_PUBLIC_IP)
+		if err != nil {
+			return "", "", fmt.Errorf("host type choose error:%s", err.Error())
+		}
+	}
+
+	return region, hostType, nil
+}
+
+func (w *ConfigWizard) StrictHostKeyChecking(ans *WizardAnswers) (int, error) {
+	if ans.StrictHostKeyCheckingNo >= 0 {
+		return ans.StrictHostKeyCheckingNo, nil
+	}
+
+	chosen, err := w.choose(ans, "rnssh StrictHostKeyChecking option", "Please select about StrictHostKeyChecking (recommend to Not specify)", StrictHostKeyCheckingList, "0")
+	if err != nil {
+		return -1, fmt.Errorf("StrictHostKeyChecking choose error:%s", err.Error())
+	}
+
+	strict, err := strconv.Atoi(chosen)
+	if err != nil {
+		// error then disabled
+		strict = 0
+	}
+
+	return strict, nil
+}
+
+func (w *ConfigWizard) SshUser(ans *WizardAnswers) (string, error) {
+	if ans.SshUser != "" || ans.NoPrompt {
+		return ans.SshUser, nil
+	}
+
+	return w.input("default ssh user (empty is detected from instance tag or AMI)")
+}
+
+func (w *ConfigWizard) IdentityFile(ans *WizardAnswers) (string, error) {
+	if ans.IdentityFile != "" || ans.NoPrompt {
+		return ans.IdentityFile, nil
+	}
+
+	choices := IdentityFileChoices(w.SshDir)
+	if len(choices) == 1 {
+		// only Not specify
+		return "", nil
+	}
+
+	chosen, err := w.choose(ans, "identity file", "Please select default identity file", choices, "")
+	if err != nil {
+		return "", fmt.Errorf("identity file choose error:%s", err.Error())
+	}
+
+	return chosen, nil
+}
+
+func (w *ConfigWizard) SshPort(ans *WizardAnswers) (int, error) {
+	if ans.Port != 0 || ans.NoPrompt {
+		return ans.Port, nil
+	}
+
+	p, err := w.input("default ssh port (empty is not specify)")
+	if err != nil || p == "" {
+		return 0, err
+	}
+
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid ssh port: %s", p)
+	}
+
+	return port, nil
+}
+
+// choose returns the value of chosen. with NoPrompt, returns defaultValue without asking.
+func (w *ConfigWizard) choose(ans *WizardAnswers, itemName, message string, choices []peco.Choosable, defaultValue string) (string, error) {
+	if ans.NoPrompt {
+		return defaultValue, nil
+	}
+
+	chosen, err := w.Selector.Choose(itemName, message, "", choices)
+	if err != nil {
+		return "", err
+	}
+
+	value := defaultValue
+	// later win
+	for _, c := range chosen {
+		value = c.Value()
+	}
+
+	return value, nil
+}
+
+// input reads a line. EOF is empty input.
+func (w *ConfigWizard) input(message string) (string, error) {
+	if w.in == nil {
+		w.in = bufio.NewReader(w.In)
+	}
+
+	fmt.Fprintf(w.Out, "%s>", message)
+	line, err := w.in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if !strings.HasSuffix(line, "\n") {
+		fmt.Fprintln(w.Out)
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// IdentityFileChoices returns Not specify and private keys in sshDir. (*.pem, id_* without .pub)
+func IdentityFileChoices(sshDir string) []peco.Choosable {
+	choices := []peco.Choosable{&peco.Choice{C: "Not specify (ssh config or ssh-agent)", V: ""}}
+
+	files, _ := filepath.Glob(filepath.Join(sshDir, "*"))
+	sort.Strings(files)
+	for _, f := range files {
+		name := filepath.Base(f)
+		if !strings.HasSuffix(name, ".pem") && !(strings.HasPrefix(name, "id_") && !strings.HasSuffix(name, ".pub")) {
+			continue
+		}
+
+		if st, err := os.Stat(f); err != nil || st.IsDir() {
+			continue
+		}
+
+		choices = append(choices, &peco.Choice{C: f, V: f})
+	}
+
+	return choices
+}
+
+// AWSRegion is region code and location name.
+type AWSRegion struct {
+	Code string
+	Name string
+}
+
+// built-in regions. used when DescribeRegions is not available. (no credentials etc...)
+var AWSRegions = []AWSRegion{
+	{"af-south-1", "Cape Town"},
+	{"ap-east-1", "Hong Kong"},
+	{"ap-east-2", "Taipei"},
+	{"ap-northeast-1", "Tokyo"},
+	{"ap-northeast-2", "Seoul"},
+	{"ap-northeast-3", "Osaka"},
+	{"ap-south-1", "Mumbai"},
+	{"ap-south-2", "Hyderabad"},
+	{"ap-southeast-1", "Singapore"},
+	{"ap-southeast-2", "Sydney"},
+	{"ap-southeast-3", "Jakarta"},
+	{"ap-southeast-4", "Melbourne"},
+	{"ap-southeast-5", "Malaysia"},
+	{"ap-southeast-6", "New Zealand"},
+	{"ap-southeast-7", "Thailand"},
+	{"ca-central-1", "Canada Central"},
+	{"ca-west-1", "Calgary"},
+	{"eu-central-1", "Frankfurt"},
+	{"eu-central-2", "Zurich"},
+	{"eu-north-1", "Stockholm"},
+	{"eu-south-1", "Milan"},
+	{"eu-south-2", "Spain"},
+	{"eu-west-1", "Ireland"},
+	{"eu-west-2", "London"},
+	{"eu-west-3", "Paris"},
+	{"il-central-1", "Tel Aviv"},
+	{"me-central-1", "UAE"},
+	{"me-south-1", "Bahrain"},
+	{"mx-central-1", "Mexico"},
+	{"sa-east-1", "Sao Paulo"},
+	{"us-east-1", "N. Virginia"},
+	{"us-east-2", "Ohio"},
+	{"us-west-1", "N. California"},
+	{"us-west-2", "Oregon"},
+}
+
+// RegionChoices converts region codes to choices with location name.
+func RegionChoices(codes []string) []peco.Choosable {
+	names := make(map[string]string, len(AWSRegions))
+	for _, r := range AWSRegions {
+		names[r.Code] = r.Name
+	}
+
+	sorted := append([]string(nil), codes...)
+	sort.Strings(sorted)
+
+	choices := make([]peco.Choosable, 0, len(sorted))
+	for _, code := range sorted {
+		c := code
+		if name, ok := names[code]; ok {
+			c = fmt.Sprintf("%s (%s)", code, name)
+		}
+		choices = append(choices, &peco.Choice{C: c, V: code})
+	}
+
+	return choices
+}
+
+func builtinRegionCodes() []string {
+	codes := make([]string, 0, len(AWSRegions))
+	for _, r := range AWSRegions {
+		codes = append(codes, r.Code)
+	}
+
+	return codes
+}
+
+var (
+	AWSRegionList = RegionChoices(builtinRegionCodes())
+
+	HostTypeList = []peco.Choosable{
+		&peco.Choice{C: "PublicIP (rnssh default)", V: "public"},
+		&peco.Choice{C: "PrivateIP (for VPN or bastion)", V: "private"},
+		&peco.Choice{C: "PublicIP, fallback to PrivateIP", V: "public,private"},
+		&peco.Choice{C: "PublicIP, fallback to PrivateIP and SSM", V: "public,private,ssm"},
+		&peco.Choice{C: "Name Tag (need ssh config settings)", V: "name"},
+		&peco.Choice{C: "SSM (connect via AWS Systems Manager Session Manager)", V: "ssm"},
+	}
+
+	StrictHostKeyCheckingList = []peco.Choosable{
+		&peco.Choice{C: "Not specify (rnssh Default)", V: "0"},
+		&peco.Choice{C: "StrictHostKeyChecking=NO (if you don't know this ssh option, then deprecated)", V: "1"},
+	}
+
+	ResourceTypeList = []peco.Choosable{
+		&peco.Choice{C: "AWS EC2 (rnssh Default)", V: RESOURCE_TYPE_EC2},
+		&peco.Choice{C: "ssh config (load from ~/.ssh/config)", V: RESOURCE_TYPE_SSH_CONFIG},
+	}
+
+	ContinueList = []peco.Choosable{
+		&peco.Choice{C: "No.", V: "no"},
+		&peco.Choice{C: "Yes, continue to AWS settings", V: "yes"},
+	}
+)
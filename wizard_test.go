@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+func loadTestConfig(t *testing.T, a *testApp) *Config {
+	t.Helper()
+
+	conf := Config{}
+	if _, err := toml.DecodeFile(filepath.Join(a.RnsshDir, "config"), &conf); err != nil {
+		t.Fatal(err)
+	}
+
+	return &conf
+}
+
+func TestAppRunInitNoPrompt(t *testing.T) {
+	a := newTestApp(t, "", "")
+
+	if code := a.Run([]string{"-init", "-no-prompt", "-r", "us-west-2", "-host-type", "private", "-l", "ec2-user", "-port", "2222"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if len(a.Selector.Choices) != 0 {
+		t.Errorf("expected no question but %v", a.Selector.Choices)
+	}
+
+	expected := RnsshConfig{AWSRegion: "us-west-2", HostType: "private", SshUser: "ec2-user", SshPort: 2222}
+	if conf := loadTestConfig(t, a); !equalConfig(conf.Default, expected) {
+		t.Errorf("expected %+v but %+v", expected, conf.Default)
+	}
+
+	// save to profile and keep Default
+	if code := a.Run([]string{"config", "init", "-profile", "local", "-use-ssh-config", "-no-prompt"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	conf := loadTestConfig(t, a)
+	if conf.Default.AWSRegion != "us-west-2" {
+		t.Errorf("expected Default is kept but %+v", conf.Default)
+	}
+
+	if len(conf.Profiles) != 1 || conf.Profiles[0].Name != "local" || !conf.Profiles[0].UseSshConfig {
+		t.Errorf("expected local profile with ssh config but %+v", conf.Profiles)
+	}
+
+	a.Out.Reset()
+	if code := a.Run([]string{"-init", "-no-prompt"}); code == 0 {
+		t.Errorf("expected error without region")
+	}
+
+	if !strings.Contains(a.Out.String(), "region is required") {
+		t.Errorf("unexpected error: %s", a.Out.String())
+	}
+}
+
+func TestAppRunInitKeepsOtherSettings(t *testing.T) {
+	config := `[Default]
+  aws_region = "ap-northeast-1"
+  ssh_user = "admin"
+  selector = "fzf"
+  ssh_user_by_ami = { ubuntu = "ubuntu" }
+
+[[profiles]]
+  profile_name = "prod"
+  aws_region = "eu-west-1"
+  ssh_identity_file_template = "~/.ssh/{{.KeyName}}.pem"
+`
+	a := newTestApp(t, "", config)
+
+	if code := a.Run([]string{"-init", "-no-prompt", "-r", "us-west-2"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	conf := loadTestConfig(t, a)
+	if conf.Default.AWSRegion != "us-west-2" || conf.Default.SshUser != "" {
+		t.Errorf("expected answered settings are saved but %+v", conf.Default)
+	}
+
+	if conf.Default.Selector != "fzf" || conf.Default.SshUserByAMI["ubuntu"] != "ubuntu" {
+		t.Errorf("expected not answered settings are kept but %+v", conf.Default)
+	}
+
+	if code := a.Run([]string{"config", "init", "-profile", "prod", "-use-ssh-config", "-no-prompt"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	conf = loadTestConfig(t, a)
+	if len(conf.Profiles) != 1 {
+		t.Fatalf("expected 1 profile but %+v", conf.Profiles)
+	}
+
+	p := conf.Profiles[0]
+	if !p.UseSshConfig || p.AWSRegion != "eu-west-1" || p.SshIdentityFileTemplate != "~/.ssh/{{.KeyName}}.pem" {
+		t.Errorf("expected profile is merged but %+v", p)
+	}
+}
+
+func TestAppRunInitRegions(t *testing.T) {
+	a := newTestApp(t, "mx-central-1", "")
+	a.EC2.Regions = []string{"us-east-1", "mx-central-1"}
+	a.Stdin = strings.NewReader("deploy\n2200\n")
+
+	if code := a.Run([]string{"-init", "-use-ec2", "-host-type", "public", "-strict-host-key-checking-no", "0"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if strings.Join(a.Selector.Choices, "\n") != "mx-central-1 (Mexico)\nus-east-1 (N. Virginia)" {
+		t.Errorf("expected regions from DescribeRegions but %v", a.Selector.Choices)
+	}
+
+	expected := RnsshConfig{AWSRegion: "mx-central-1", HostType: "public", SshUser: "deploy", SshPort: 2200}
+	if conf := loadTestConfig(t, a); !equalConfig(conf.Default, expected) {
+		t.Errorf("expected %+v but %+v", expected, conf.Default)
+	}
+
+	// fallback to built-in list
+	a = newTestApp(t, "eu-west-3", "")
+	a.EC2.Err = errors.New("no credentials")
+
+	if code := a.Run([]string{"-init", "-use-ec2", "-host-type", "public", "-strict-host-key-checking-no", "0"}); code != 0 {
+		t.Fatalf("expected exit code 0 but %d: %s", code, a.Out.String())
+	}
+
+	if len(a.Selector.Choices) != len(AWSRegions) || !strings.Contains(a.Out.String(), "use built-in list") {
+		t.Errorf("expected built-in regions but %v: %s", a.Selector.Choices, a.Out.String())
+	}
+
+	if conf := loadTestConfig(t, a); conf.Default.AWSRegion != "eu-west-3" {
+		t.Errorf("expected eu-west-3 but %+v", conf.Default)
+	}
+}
+
+func TestIdentityFileChoices(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"id_ed25519", "id_ed25519.pub", "work.pem", "config", "known_hosts"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values := make([]string, 0)
+	for _, c := range IdentityFileChoices(dir) {
+		values = append(values, c.Value())
+	}
+
+	expected := []string{"", filepath.Join(dir, "id_ed25519"), filepath.Join(dir, "work.pem")}
+	if strings.Join(values, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v but %v", expected, values)
+	}
+}
+
+func equalConfig(a, b RnsshConfig) bool {
+	return a.AWSRegion == b.AWSRegion && a.HostType == b.HostType && a.SshUser == b.SshUser &&
+		a.SshPort == b.SshPort && a.UseSshConfig == b.UseSshConfig && a.SshIdentityFile == b.SshIdentityFile
+}